@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Audited actions on a single image.
+const (
+	auditRead      = "read"
+	auditDownload  = "download"
+	auditUpload    = "upload"
+	auditTag       = "tag"
+	auditUntag     = "untag"
+	auditReprocess = "reprocess"
+	auditDelete    = "delete"
+	auditRestore   = "restore"
+)
+
+// AuditRecord is an entry of the append-only audit log.
+type AuditRecord struct {
+	// ID orders the records; it is assigned by the log.
+	ID int64 `json:"id"`
+
+	// CreatedAt is when the action happened.
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Actor is the caller named by the actor header, or anonymous.
+	Actor string `json:"actor"`
+
+	// Action is what the actor did, e.g. read or delete.
+	Action string `json:"action"`
+
+	// ImageUUID is the image the action applied to.
+	ImageUUID string `json:"imageUuid"`
+
+	// ClientIP is the address of the client.
+	ClientIP string `json:"clientIp"`
+
+	// UserAgent is the User-Agent header of the request.
+	UserAgent string `json:"userAgent"`
+
+	// TraceID links the record to the trace of the request, if it was sampled.
+	TraceID string `json:"traceId,omitempty"`
+}
+
+// AuditFilter narrows down audit queries; zero values match everything.
+type AuditFilter struct {
+	// Actor matches the actor exactly.
+	Actor string
+
+	// Action matches the action exactly.
+	Action string
+
+	// ImageUUID matches the image exactly.
+	ImageUUID string
+
+	// From is the inclusive lower bound for created_at.
+	From *time.Time
+
+	// To is the exclusive upper bound for created_at.
+	To *time.Time
+
+	// Limit is the maximum number of records to return; zero means no limit.
+	Limit int
+
+	// Offset is the number of records to skip.
+	Offset int
+}
+
+// AuditLog stores audit records. Records are never changed or removed.
+type AuditLog interface {
+	// Record appends the record and sets its ID and creation time.
+	Record(ctx context.Context, r *AuditRecord) error
+
+	// List returns a page of matching records, newest first.
+	List(ctx context.Context, f AuditFilter) ([]*AuditRecord, error)
+
+	// Export calls fn for every matching record, oldest first, without
+	// loading them all at once. It stops at the first error fn returns.
+	Export(ctx context.Context, f AuditFilter, fn func(*AuditRecord) error) error
+}
+
+// auditColumns lists the go_image_audit columns in the order scanAudit reads them.
+const auditColumns = `id, created_at, actor, action, image_uuid, client_ip, user_agent, trace_id`
+
+// pgAuditLog keeps the audit records in Postgres.
+type pgAuditLog struct {
+	// Postgres connection pool
+	dbpool *pgxpool.Pool
+
+	// Prometheus metrics
+	metrics *metrics
+}
+
+// newPgAuditLog returns a log backed by the go_image_audit table.
+func newPgAuditLog(dbpool *pgxpool.Pool, m *metrics) *pgAuditLog {
+	return &pgAuditLog{dbpool: dbpool, metrics: m}
+}
+
+// Record inserts the record.
+func (l *pgAuditLog) Record(ctx context.Context, r *AuditRecord) error {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL INSERT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := `INSERT INTO go_image_audit (actor, action, image_uuid, client_ip, user_agent, trace_id)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`
+
+	err := l.dbpool.QueryRow(ctx, query, r.Actor, r.Action, r.ImageUUID, r.ClientIP, r.UserAgent, r.TraceID).
+		Scan(&r.ID, &r.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("dbpool.QueryRow failed: %w", err)
+	}
+
+	// Record the duration of the insert query.
+	l.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return nil
+}
+
+// List returns a page of matching records, newest first.
+func (l *pgAuditLog) List(ctx context.Context, f AuditFilter) ([]*AuditRecord, error) {
+	records := []*AuditRecord{}
+	err := l.query(ctx, f, "id DESC", func(r *AuditRecord) error {
+		records = append(records, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// Export streams the matching records from the database, oldest first.
+func (l *pgAuditLog) Export(ctx context.Context, f AuditFilter, fn func(*AuditRecord) error) error {
+	return l.query(ctx, f, "id", fn)
+}
+
+// query selects the matching records in the given order and passes them to fn one by one.
+func (l *pgAuditLog) query(ctx context.Context, f AuditFilter, order string, fn func(*AuditRecord) error) error {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL SELECT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	var conds []string
+	var args []any
+	if f.Actor != "" {
+		args = append(args, f.Actor)
+		conds = append(conds, fmt.Sprintf("actor = $%d", len(args)))
+	}
+	if f.Action != "" {
+		args = append(args, f.Action)
+		conds = append(conds, fmt.Sprintf("action = $%d", len(args)))
+	}
+	if f.ImageUUID != "" {
+		args = append(args, f.ImageUUID)
+		conds = append(conds, fmt.Sprintf("image_uuid = $%d", len(args)))
+	}
+	if f.From != nil {
+		args = append(args, *f.From)
+		conds = append(conds, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if f.To != nil {
+		args = append(args, *f.To)
+		conds = append(conds, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+
+	query := fmt.Sprintf(`SELECT %s FROM go_image_audit`, auditColumns)
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY " + order
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if f.Offset > 0 {
+		args = append(args, f.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+
+	rows, err := l.dbpool.Query(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("dbpool.Query failed: %w", err)
+	}
+
+	var r AuditRecord
+	_, err = pgx.ForEachRow(rows, []any{&r.ID, &r.CreatedAt, &r.Actor, &r.Action, &r.ImageUUID, &r.ClientIP, &r.UserAgent, &r.TraceID},
+		func() error {
+			c := r
+			return fn(&c)
+		})
+	if err != nil {
+		return fmt.Errorf("pgx.ForEachRow failed: %w", err)
+	}
+
+	// Record the duration of the select query.
+	l.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return nil
+}
+
+// memoryAuditLog keeps the audit records in memory; it is meant for tests and demos.
+type memoryAuditLog struct {
+	mu      sync.RWMutex
+	records []AuditRecord
+}
+
+// newMemoryAuditLog returns an empty in-memory log.
+func newMemoryAuditLog() *memoryAuditLog {
+	return &memoryAuditLog{}
+}
+
+// Record appends a copy of the record.
+func (l *memoryAuditLog) Record(ctx context.Context, r *AuditRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r.ID = int64(len(l.records) + 1)
+	r.CreatedAt = time.Now()
+	l.records = append(l.records, *r)
+
+	return nil
+}
+
+// List returns copies of a page of matching records, newest first.
+func (l *memoryAuditLog) List(ctx context.Context, f AuditFilter) ([]*AuditRecord, error) {
+	records := l.match(f)
+	slices.Reverse(records)
+
+	if f.Offset >= len(records) {
+		return []*AuditRecord{}, nil
+	}
+	records = records[f.Offset:]
+	if f.Limit > 0 && f.Limit < len(records) {
+		records = records[:f.Limit]
+	}
+
+	return records, nil
+}
+
+// Export passes copies of the matching records to fn, oldest first.
+func (l *memoryAuditLog) Export(ctx context.Context, f AuditFilter, fn func(*AuditRecord) error) error {
+	records := l.match(f)
+
+	if f.Offset >= len(records) {
+		return nil
+	}
+	records = records[f.Offset:]
+	if f.Limit > 0 && f.Limit < len(records) {
+		records = records[:f.Limit]
+	}
+
+	for _, r := range records {
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// match returns copies of all records matching the filter conditions, oldest first.
+func (l *memoryAuditLog) match(f AuditFilter) []*AuditRecord {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	records := []*AuditRecord{}
+	for _, r := range l.records {
+		if f.Actor != "" && r.Actor != f.Actor {
+			continue
+		}
+		if f.Action != "" && r.Action != f.Action {
+			continue
+		}
+		if f.ImageUUID != "" && r.ImageUUID != f.ImageUUID {
+			continue
+		}
+		if f.From != nil && r.CreatedAt.Before(*f.From) {
+			continue
+		}
+		if f.To != nil && !r.CreatedAt.Before(*f.To) {
+			continue
+		}
+		c := r
+		records = append(records, &c)
+	}
+
+	return records
+}
+
+// audit records that the caller of the request performed the action on the
+// image. A failed write is logged but does not fail the request.
+func (h *handler) audit(ctx context.Context, c *gin.Context, action string, imageUUID string) {
+	actor := strings.TrimSpace(c.GetHeader(h.config.Audit.ActorHeader))
+	if actor == "" {
+		actor = "anonymous"
+	}
+
+	r := &AuditRecord{
+		Actor:     actor,
+		Action:    action,
+		ImageUUID: imageUUID,
+		ClientIP:  c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		r.TraceID = sc.TraceID().String()
+	}
+
+	if err := h.auditLog.Record(ctx, r); err != nil {
+		log.Printf("auditLog.Record failed: %v", err)
+	}
+}
+
+// auditFilter parses the audit filters and the page from the query string.
+func auditFilter(c *gin.Context) (AuditFilter, error) {
+	f := AuditFilter{
+		Actor:     c.Query("actor"),
+		Action:    c.Query("action"),
+		ImageUUID: c.Query("image_uuid"),
+	}
+
+	var err error
+	if f.From, err = queryTime(c, "from"); err != nil {
+		return f, err
+	}
+	if f.To, err = queryTime(c, "to"); err != nil {
+		return f, err
+	}
+
+	return f, nil
+}
+
+// listAudit responds with a page of audit records, newest first.
+func (h *handler) listAudit(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP GET /api/audit")
+	defer span.End()
+
+	f, err := auditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if f.Limit, f.Offset, err = pagination(c); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	records, err := h.auditLog.List(ctx, f)
+	if err != nil {
+		log.Printf("auditLog.List failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":  records,
+		"limit":  f.Limit,
+		"offset": f.Offset,
+	})
+}
+
+// exportAudit streams all matching audit records as newline-delimited JSON,
+// oldest first. The optional limit caps the number of records.
+func (h *handler) exportAudit(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP GET /api/audit/export")
+	defer span.End()
+
+	f, err := auditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if v := c.Query("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid limit"})
+			return
+		}
+	}
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", `attachment; filename="audit.ndjson"`)
+	c.Status(http.StatusOK)
+
+	// Flush every few hundred records so the client sees progress on large exports.
+	w := bufio.NewWriter(c.Writer)
+	enc := json.NewEncoder(w)
+	n := 0
+	err = h.auditLog.Export(ctx, f, func(r *AuditRecord) error {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+		if n++; n%500 == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		// The status line is already sent; the client sees a truncated stream.
+		log.Printf("auditLog.Export failed: %v", err)
+		return
+	}
+
+	if err := w.Flush(); err != nil {
+		log.Printf("audit export failed: %v", err)
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// BucketEvent is a bucket notification about a single object.
+type BucketEvent struct {
+	// ObjectKey is the key of the object, already URL-decoded.
+	ObjectKey string
+
+	// EventName is the event type without the "s3:" prefix, e.g. ObjectCreated:Put.
+	EventName string
+
+	// Sequencer orders the events of a single key; it is a hex string.
+	Sequencer string
+
+	// ETag is the entity tag of created objects.
+	ETag string
+
+	// AppliedAt is when the event was applied.
+	AppliedAt time.Time
+}
+
+// appliedAfter reports whether the event was already applied, or superseded by
+// a later one, given the last event applied to the same key.
+func (e *BucketEvent) appliedAfter(last *BucketEvent) bool {
+	if last == nil {
+		return false
+	}
+
+	// Sequencers are only comparable after padding them to the same length.
+	if e.Sequencer != "" && last.Sequencer != "" {
+		n := max(len(e.Sequencer), len(last.Sequencer))
+		pad := func(s string) string { return strings.Repeat("0", n-len(s)) + strings.ToUpper(s) }
+		return pad(last.Sequencer) >= pad(e.Sequencer)
+	}
+
+	// Without a sequencer only an exact redelivery can be recognized.
+	return e.ETag != "" && last.EventName == e.EventName && last.ETag == e.ETag
+}
+
+// BucketEventLog remembers the last bucket event applied to every object key.
+type BucketEventLog interface {
+	// Last returns the last event applied to the key, or nil if there is none.
+	Last(ctx context.Context, key string) (*BucketEvent, error)
+
+	// Record saves the event as the last one applied to its key.
+	Record(ctx context.Context, e *BucketEvent) error
+}
+
+// pgBucketEventLog keeps the applied events in Postgres.
+type pgBucketEventLog struct {
+	// Postgres connection pool
+	dbpool *pgxpool.Pool
+
+	// Prometheus metrics
+	metrics *metrics
+}
+
+// newPgBucketEventLog returns a log backed by the go_image_bucket_event table.
+func newPgBucketEventLog(dbpool *pgxpool.Pool, m *metrics) *pgBucketEventLog {
+	return &pgBucketEventLog{dbpool: dbpool, metrics: m}
+}
+
+// Last loads the last event applied to the key.
+func (l *pgBucketEventLog) Last(ctx context.Context, key string) (*BucketEvent, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL SELECT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := `SELECT object_key, event_name, sequencer, etag, applied_at
+		FROM go_image_bucket_event WHERE object_key = $1`
+
+	var e BucketEvent
+	err := l.dbpool.QueryRow(ctx, query, key).Scan(&e.ObjectKey, &e.EventName, &e.Sequencer, &e.ETag, &e.AppliedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dbpool.QueryRow failed: %w", err)
+	}
+
+	// Record the duration of the select query.
+	l.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return &e, nil
+}
+
+// Record upserts the event as the last one applied to its key.
+func (l *pgBucketEventLog) Record(ctx context.Context, e *BucketEvent) error {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL INSERT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := `INSERT INTO go_image_bucket_event (object_key, event_name, sequencer, etag, applied_at)
+		VALUES ($1, $2, $3, $4, now())
+		ON CONFLICT (object_key) DO UPDATE
+		SET event_name = EXCLUDED.event_name, sequencer = EXCLUDED.sequencer,
+		    etag = EXCLUDED.etag, applied_at = EXCLUDED.applied_at`
+
+	_, err := l.dbpool.Exec(ctx, query, e.ObjectKey, e.EventName, e.Sequencer, e.ETag)
+	if err != nil {
+		return fmt.Errorf("dbpool.Exec failed: %w", err)
+	}
+
+	// Record the duration of the insert query.
+	l.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return nil
+}
+
+// memoryBucketEventLog keeps the applied events in memory; it is meant for tests and demos.
+type memoryBucketEventLog struct {
+	mu     sync.Mutex
+	events map[string]BucketEvent
+}
+
+// newMemoryBucketEventLog returns an empty in-memory log.
+func newMemoryBucketEventLog() *memoryBucketEventLog {
+	return &memoryBucketEventLog{events: make(map[string]BucketEvent)}
+}
+
+// Last returns a copy of the last event applied to the key.
+func (l *memoryBucketEventLog) Last(ctx context.Context, key string) (*BucketEvent, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.events[key]
+	if !ok {
+		return nil, nil
+	}
+
+	return &e, nil
+}
+
+// Record saves a copy of the event.
+func (l *memoryBucketEventLog) Record(ctx context.Context, e *BucketEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c := *e
+	c.AppliedAt = time.Now()
+	l.events[e.ObjectKey] = c
+
+	return nil
+}
+
+// bucketEventRecord is a record of the S3 event notification JSON that MinIO and AWS send.
+type bucketEventRecord struct {
+	EventName string `json:"eventName"`
+	S3        struct {
+		Bucket struct {
+			Name string `json:"name"`
+		} `json:"bucket"`
+		Object struct {
+			Key       string `json:"key"`
+			ETag      string `json:"eTag"`
+			Sequencer string `json:"sequencer"`
+		} `json:"object"`
+	} `json:"s3"`
+}
+
+// postBucketEvents applies bucket notifications: created objects are ingested
+// and removed objects mark their images as missing. Events that were already
+// applied are skipped, so the bucket may retry deliveries safely.
+func (h *handler) postBucketEvents(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP POST /api/events/s3")
+	defer span.End()
+
+	if !h.authorizeBucketEvents(c) {
+		return
+	}
+
+	var body struct {
+		Records []bucketEventRecord `json:"Records"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid request body"})
+		return
+	}
+
+	var applied, skipped int
+	for _, r := range body.Records {
+		// Ignore other buckets and the derivatives and firmware artifacts we write ourselves.
+		key, err := url.QueryUnescape(r.S3.Object.Key)
+		if err != nil || r.S3.Bucket.Name != h.config.S3Config.Bucket || managedObject(key) {
+			skipped++
+			continue
+		}
+
+		e := &BucketEvent{
+			ObjectKey: key,
+			EventName: strings.TrimPrefix(r.EventName, "s3:"),
+			Sequencer: r.S3.Object.Sequencer,
+			ETag:      strings.Trim(r.S3.Object.ETag, `"`),
+		}
+
+		last, err := h.bucketEvents.Last(ctx, key)
+		if err != nil {
+			log.Printf("bucketEvents.Last failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+			return
+		}
+		if e.appliedAfter(last) {
+			skipped++
+			continue
+		}
+
+		// Fail the delivery so the bucket retries it; applied events are skipped then.
+		if err := h.applyBucketEvent(ctx, e); err != nil {
+			log.Printf("applyBucketEvent failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal error"})
+			return
+		}
+		if err := h.bucketEvents.Record(ctx, e); err != nil {
+			log.Printf("bucketEvents.Record failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+			return
+		}
+		applied++
+	}
+
+	c.JSON(http.StatusOK, gin.H{"applied": applied, "skipped": skipped})
+}
+
+// authorizeBucketEvents checks the shared secret, accepting both a bare token
+// and a bearer token. It responds with an error and returns false on mismatch.
+func (h *handler) authorizeBucketEvents(c *gin.Context) bool {
+	secret := h.config.Events.Secret
+	if secret == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": "bucket event webhook is not configured"})
+		return false
+	}
+
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "invalid secret"})
+		return false
+	}
+
+	return true
+}
+
+// applyBucketEvent brings the images of the object key in line with the event.
+func (h *handler) applyBucketEvent(ctx context.Context, e *BucketEvent) error {
+	images, err := h.images.List(ctx, ImageFilter{ObjectKey: e.ObjectKey})
+	if err != nil {
+		return fmt.Errorf("images.List failed: %w", err)
+	}
+
+	switch {
+	case strings.HasPrefix(e.EventName, "ObjectCreated:"):
+		// Hash the object the same way the ingest endpoint does.
+		info, hash, err := hashObject(ctx, h.store, e.ObjectKey)
+		if errors.Is(err, ErrObjectNotFound) {
+			// The object is already gone again; its removal event follows.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// Objects that already have a row are processed again when they were
+		// missing or were overwritten with other content. The worker keeps an
+		// existing hash, so store the new one first.
+		if len(images) > 0 {
+			for _, image := range images {
+				reason := "object created in bucket"
+				if image.ContentHash != hash {
+					h.recordHash(ctx, image, hash)
+					reason = "object overwritten in bucket"
+				} else if image.Status != StatusMissing {
+					continue
+				}
+
+				// An image still waiting for a worker keeps its status; the
+				// worker reads the new content, so only make sure one is queued.
+				if image.Status == StatusUploaded {
+					if err := h.jobs.Enqueue(ctx, image.ImageUUID); err != nil {
+						return fmt.Errorf("jobs.Enqueue failed: %w", err)
+					}
+					continue
+				}
+				if err := h.requeue(ctx, image.ImageUUID, reason); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		image := newObjectImage(info)
+		image.ContentHash = hash
+		if _, _, err := h.ingest(ctx, image); err != nil {
+			return err
+		}
+	case strings.HasPrefix(e.EventName, "ObjectRemoved:"):
+		for _, image := range images {
+			if image.Status == StatusMissing {
+				continue
+			}
+			if err := h.images.UpdateStatus(ctx, image.ImageUUID, StatusMissing, "object removed from bucket"); err != nil {
+				return fmt.Errorf("images.UpdateStatus failed: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyBucketEventOverwrite(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		status     string
+		wantStatus string
+	}{
+		{"waiting for a worker", StatusUploaded, StatusUploaded},
+		{"processed", StatusProcessed, StatusUploaded},
+		{"error", StatusError, StatusUploaded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newTestRouter(t)
+
+			image := NewImage("camera/a.png", 3, time.Now())
+			image.ContentHash = "old"
+			if err := h.images.Insert(ctx, image); err != nil {
+				t.Fatalf("images.Insert failed: %v", err)
+			}
+			if tt.status != StatusUploaded {
+				for _, s := range []string{StatusProcessing, tt.status} {
+					if err := h.images.UpdateStatus(ctx, image.ImageUUID, s, ""); err != nil {
+						t.Fatalf("images.UpdateStatus failed: %v", err)
+					}
+				}
+			}
+			depth, _ := h.jobs.Depth(ctx)
+
+			if err := h.store.Put(ctx, image.ObjectKey, strings.NewReader("new"), "image/png"); err != nil {
+				t.Fatalf("store.Put failed: %v", err)
+			}
+			e := &BucketEvent{ObjectKey: image.ObjectKey, EventName: "ObjectCreated:Put"}
+			if err := h.applyBucketEvent(ctx, e); err != nil {
+				t.Fatalf("applyBucketEvent failed: %v", err)
+			}
+
+			got, err := h.images.Get(ctx, image.ImageUUID)
+			if err != nil {
+				t.Fatalf("images.Get failed: %v", err)
+			}
+			sum := sha256.Sum256([]byte("new"))
+			if want := hex.EncodeToString(sum[:]); got.ContentHash != want {
+				t.Errorf("got hash %s, want %s", got.ContentHash, want)
+			}
+			if got.Status != tt.wantStatus {
+				t.Errorf("got status %s, want %s", got.Status, tt.wantStatus)
+			}
+			if n, _ := h.jobs.Depth(ctx); n != depth+1 {
+				t.Errorf("got %d queued jobs, want %d", n, depth+1)
+			}
+
+			// A redelivery with unchanged content leaves the image alone.
+			if err := h.applyBucketEvent(ctx, e); err != nil {
+				t.Fatalf("applyBucketEvent failed on redelivery: %v", err)
+			}
+			if n, _ := h.jobs.Depth(ctx); n != depth+1 {
+				t.Errorf("got %d queued jobs after redelivery, want %d", n, depth+1)
+			}
+		})
+	}
+}
@@ -15,11 +15,144 @@ type Config struct {
 	// OTLP Endpoint to send traces.
 	OTLPEndpoint string `yaml:"otlpEndpoint"`
 
+	// Storage config to select the object store backend.
+	Storage StorageConfig `yaml:"storage"`
+
 	// S3 config to connect to a bucket.
 	S3Config S3Config `yaml:"s3"`
 
 	// DB config to connect to a database.
 	DbConfig DbConfig `yaml:"db"`
+
+	// Derivatives to generate for every processed image.
+	Derivatives []DerivativeConfig `yaml:"derivatives"`
+
+	// Workers config for the background image processing.
+	Workers WorkerConfig `yaml:"workers"`
+
+	// Reconcile config for syncing the database with the bucket.
+	Reconcile ReconcileConfig `yaml:"reconcile"`
+
+	// Events config for the bucket notification webhook.
+	Events EventsConfig `yaml:"events"`
+
+	// Retention rules to expire old images.
+	Retention RetentionConfig `yaml:"retention"`
+
+	// Audit config for the image access log.
+	Audit AuditConfig `yaml:"audit"`
+
+	// Devices config for the heartbeat tracking.
+	Devices DevicesConfig `yaml:"devices"`
+
+	// Telemetry config for the device readings.
+	Telemetry TelemetryConfig `yaml:"telemetry"`
+}
+
+type TelemetryConfig struct {
+	// Metrics devices may report; samples of other metrics are rejected.
+	Metrics []string `yaml:"metrics"`
+
+	// Largest number of samples accepted in a single request.
+	MaxBatch int `yaml:"maxBatch"`
+
+	// Largest number of buckets a query may return per metric.
+	MaxBuckets int `yaml:"maxBuckets"`
+
+	// How far ahead of the server clock a sample may be taken, in seconds.
+	MaxFutureSeconds int `yaml:"maxFutureSeconds"`
+
+	// Number of devices whose latest values are exported as gauges; the rest
+	// are left out to bound the label cardinality.
+	MaxGaugeDevices int `yaml:"maxGaugeDevices"`
+
+	// Devices without samples for this many seconds stop being exported,
+	// freeing their slot for another device.
+	GaugeExpirySeconds int `yaml:"gaugeExpirySeconds"`
+}
+
+type DevicesConfig struct {
+	// Devices without a heartbeat for this many seconds are marked offline.
+	OfflineAfterSeconds int `yaml:"offlineAfterSeconds"`
+
+	// How often the offline detector runs, in seconds; 0 disables it.
+	CheckIntervalSeconds int `yaml:"checkIntervalSeconds"`
+
+	// How often the device gauges are refreshed from the registry, in seconds.
+	GaugeIntervalSeconds int `yaml:"gaugeIntervalSeconds"`
+}
+
+type AuditConfig struct {
+	// Request header that names the caller in audit records.
+	ActorHeader string `yaml:"actorHeader"`
+}
+
+type RetentionConfig struct {
+	// How often the rules are applied in the background, in seconds; 0 disables the sweeper.
+	IntervalSeconds int `yaml:"intervalSeconds"`
+
+	// Number of images loaded and deleted at a time.
+	BatchSize int `yaml:"batchSize"`
+
+	// Only log what would be deleted.
+	DryRun bool `yaml:"dryRun"`
+
+	// Delete images whose object is older than this many days; 0 keeps them forever.
+	MaxAgeDays int `yaml:"maxAgeDays"`
+
+	// Delete images with the error status after this many hours; 0 keeps them.
+	ErrorMaxAgeHours int `yaml:"errorMaxAgeHours"`
+
+	// Keep only the newest N images carrying each tag.
+	KeepLastPerTag map[string]int `yaml:"keepLastPerTag"`
+
+	// Purge deleted images and their objects after this many hours.
+	DeletedGraceHours int `yaml:"deletedGraceHours"`
+
+	// How often deleted images past the grace period are purged, in seconds;
+	// 0 disables the purge. DryRun does not apply to it.
+	PurgeIntervalSeconds int `yaml:"purgeIntervalSeconds"`
+}
+
+type EventsConfig struct {
+	// Shared secret the bucket sends in the Authorization header; the webhook is disabled when empty.
+	Secret string `yaml:"secret"`
+}
+
+type ReconcileConfig struct {
+	// How often the bucket is reconciled in the background, in seconds; 0 disables it.
+	IntervalSeconds int `yaml:"intervalSeconds"`
+}
+
+type WorkerConfig struct {
+	// Number of images processed concurrently.
+	Count int `yaml:"count"`
+
+	// How often idle workers poll the job queue, in milliseconds.
+	PollIntervalMs int `yaml:"pollIntervalMs"`
+
+	// How many times a failing job is tried before the image is marked as error.
+	MaxAttempts int `yaml:"maxAttempts"`
+
+	// Running jobs not finished after this many seconds are considered
+	// abandoned and claimed again; it must exceed the longest job.
+	StaleAfterSeconds int `yaml:"staleAfterSeconds"`
+}
+
+type DerivativeConfig struct {
+	// Size of the bounding box in pixels.
+	Size int `yaml:"size"`
+
+	// Mode is fit (keep the whole image) or fill (cover the box and crop).
+	Mode string `yaml:"mode"`
+}
+
+type StorageConfig struct {
+	// Backend to store images: s3 (default), fs or memory.
+	Backend string `yaml:"backend"`
+
+	// Directory to store images when using the fs backend.
+	Dir string `yaml:"dir"`
 }
 
 type S3Config struct {
@@ -41,9 +174,15 @@ type S3Config struct {
 
 	// Enable path S3 style; we must enable it to use Minio.
 	PathStyle bool `yaml:"pathStyle"`
+
+	// How long presigned upload and download URLs stay valid, in seconds.
+	PresignTTLSeconds int `yaml:"presignTTLSeconds"`
 }
 
 type DbConfig struct {
+	// Backend to store image metadata: postgres (default) or memory.
+	Backend string `yaml:"backend"`
+
 	// User to connect database.
 	User string `yaml:"user"`
 
@@ -55,6 +194,9 @@ type DbConfig struct {
 
 	// Database to store images.
 	Database string `yaml:"database"`
+
+	// Apply pending schema migrations at startup.
+	AutoMigrate bool `yaml:"autoMigrate"`
 }
 
 // loadConfig loads app config from YAML file.
@@ -71,4 +213,70 @@ func (c *Config) loadConfig(path string) {
 	if err != nil {
 		log.Fatalf("yaml.Unmarshal failed: %v", err)
 	}
+
+	// Fall back to sensible defaults for the worker pool.
+	if c.Workers.Count <= 0 {
+		c.Workers.Count = 4
+	}
+	if c.Workers.PollIntervalMs <= 0 {
+		c.Workers.PollIntervalMs = 1000
+	}
+	if c.Workers.MaxAttempts <= 0 {
+		c.Workers.MaxAttempts = 3
+	}
+	if c.Workers.StaleAfterSeconds <= 0 {
+		c.Workers.StaleAfterSeconds = 600
+	}
+
+	// Sweep expired images in batches of 100 unless configured otherwise.
+	if c.Retention.BatchSize <= 0 {
+		c.Retention.BatchSize = 100
+	}
+
+	// Callers name themselves in the X-Actor header unless configured otherwise.
+	if c.Audit.ActorHeader == "" {
+		c.Audit.ActorHeader = "X-Actor"
+	}
+
+	// Devices go offline after five minutes of silence unless configured otherwise.
+	if c.Devices.OfflineAfterSeconds <= 0 {
+		c.Devices.OfflineAfterSeconds = 300
+	}
+
+	// Refresh the device gauges every 30 seconds unless configured otherwise.
+	if c.Devices.GaugeIntervalSeconds <= 0 {
+		c.Devices.GaugeIntervalSeconds = 30
+	}
+
+	// Devices report temperature, battery and signal strength unless configured otherwise.
+	if len(c.Telemetry.Metrics) == 0 {
+		c.Telemetry.Metrics = []string{"temperature", "battery", "signal"}
+	}
+	if c.Telemetry.MaxBatch <= 0 {
+		c.Telemetry.MaxBatch = 5000
+	}
+	if c.Telemetry.MaxBuckets <= 0 {
+		c.Telemetry.MaxBuckets = 1000
+	}
+	if c.Telemetry.MaxFutureSeconds <= 0 {
+		c.Telemetry.MaxFutureSeconds = 300
+	}
+	if c.Telemetry.MaxGaugeDevices <= 0 {
+		c.Telemetry.MaxGaugeDevices = 1000
+	}
+
+	// Silent devices stop being exported once they would be offline unless configured otherwise.
+	if c.Telemetry.GaugeExpirySeconds <= 0 {
+		c.Telemetry.GaugeExpirySeconds = c.Devices.OfflineAfterSeconds
+	}
+
+	// Deleted images can be restored for a week unless configured otherwise.
+	if c.Retention.DeletedGraceHours <= 0 {
+		c.Retention.DeletedGraceHours = 168
+	}
+
+	// Presigned URLs are valid for 15 minutes unless configured otherwise.
+	if c.S3Config.PresignTTLSeconds <= 0 {
+		c.S3Config.PresignTTLSeconds = 900
+	}
 }
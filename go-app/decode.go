@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"net/http"
+
+	// Register the decoders for the supported image formats.
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+)
+
+// sniffLen is the number of bytes http.DetectContentType looks at.
+const sniffLen = 512
+
+// maxPixels protects the decoder against images that would need too much memory.
+const maxPixels = 50_000_000
+
+// supportedContentTypes lists the formats that can be decoded.
+var supportedContentTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// analyze sniffs the content type from the start of the stream and decodes
+// supported formats to record their dimensions. Images that cannot be decoded
+// are marked with the error status and a reason. It returns the decoded image,
+// if any. The reader is consumed only as far as the decoder needs it.
+func (i *Image) analyze(r io.Reader) image.Image {
+	// Detect the format by its magic number rather than trusting the file name.
+	br := bufio.NewReaderSize(r, sniffLen)
+	head, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		i.fail(fmt.Sprintf("read failed: %v", err))
+		return nil
+	}
+	i.ContentType = http.DetectContentType(head)
+
+	if !supportedContentTypes[i.ContentType] {
+		i.fail(fmt.Sprintf("unsupported content type %s", i.ContentType))
+		return nil
+	}
+
+	// Read the header first to reject huge images before allocating them. The
+	// header bytes are kept so the full decode can replay them from the stream.
+	var header bytes.Buffer
+	cfg, _, err := image.DecodeConfig(io.TeeReader(br, &header))
+	if err != nil {
+		i.fail(fmt.Sprintf("decode config failed: %v", err))
+		return nil
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		i.fail(fmt.Sprintf("image is too large: %dx%d", cfg.Width, cfg.Height))
+		return nil
+	}
+
+	i.Width = cfg.Width
+	i.Height = cfg.Height
+	i.ColorModel = colorModelName(cfg.ColorModel)
+
+	// The JPEG decoder reads all segments before the frame header, so the
+	// header bytes hold the EXIF metadata.
+	if i.ContentType == "image/jpeg" {
+		i.setExif(header.Bytes())
+	}
+
+	// Decode the whole image to make sure the data is not truncated or corrupt.
+	img, _, err := image.Decode(io.MultiReader(&header, br))
+	if err != nil {
+		i.fail(fmt.Sprintf("decode failed: %v", err))
+		return nil
+	}
+
+	return img
+}
+
+// setExif records the EXIF metadata of a JPEG header. Images without EXIF, or
+// with metadata that cannot be parsed, keep none.
+func (i *Image) setExif(header []byte) {
+	e, err := parseJPEGExif(header)
+	if err != nil {
+		e = &exifData{}
+	}
+
+	i.TakenAt = e.TakenAt
+	i.CameraMake = e.Make
+	i.CameraModel = e.Model
+	i.Orientation = e.Orientation
+	i.Latitude = e.Latitude
+	i.Longitude = e.Longitude
+}
+
+// fail marks the image as failed with the given reason.
+func (i *Image) fail(reason string) {
+	i.Status = StatusError
+	i.StatusReason = reason
+}
+
+// colorModelName returns a readable name for the color model.
+func colorModelName(m color.Model) string {
+	switch m {
+	case color.RGBAModel:
+		return "rgba"
+	case color.RGBA64Model:
+		return "rgba64"
+	case color.NRGBAModel:
+		return "nrgba"
+	case color.NRGBA64Model:
+		return "nrgba64"
+	case color.AlphaModel:
+		return "alpha"
+	case color.Alpha16Model:
+		return "alpha16"
+	case color.GrayModel:
+		return "gray"
+	case color.Gray16Model:
+		return "gray16"
+	case color.CMYKModel:
+		return "cmyk"
+	case color.YCbCrModel:
+		return "ycbcr"
+	case color.NYCbCrAModel:
+		return "nycbcra"
+	}
+
+	// Paletted images (GIF, indexed PNG) use their palette as the model.
+	if _, ok := m.(color.Palette); ok {
+		return "paletted"
+	}
+
+	return "unknown"
+}
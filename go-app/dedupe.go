@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"sort"
+)
+
+// runDedupe handles the "dedupe [-dry-run]" subcommand. It hashes images that
+// were ingested before content hashing existed and collapses every group of
+// images with the same content into a single image.
+func runDedupe(c *Config, args []string) {
+	flags := flag.NewFlagSet("dedupe", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "Only report the duplicates without removing them")
+	flags.Parse(args)
+
+	h := newCommandHandler(c)
+
+	ctx := context.Background()
+
+	// Load every image before changing anything so paging is not disturbed.
+	images, err := h.allImages(ctx, ImageFilter{})
+	if err != nil {
+		log.Fatalf("allImages failed: %v", err)
+	}
+
+	// Group the images by content hash, streaming the ones without a hash.
+	groups := make(map[string][]*Image)
+	for _, image := range images {
+		hash := image.ContentHash
+		if hash == "" {
+			var err error
+			_, hash, err = hashObject(ctx, h.store, image.ObjectKey)
+			if errors.Is(err, ErrObjectNotFound) {
+				log.Printf("skipping %s: object %s not found", image.ImageUUID, image.ObjectKey)
+				continue
+			}
+			if err != nil {
+				log.Fatalf("hashObject failed: %v", err)
+			}
+		}
+		groups[hash] = append(groups[hash], image)
+	}
+
+	var removed int
+	for hash, group := range groups {
+		// Keep the image that already owns the hash, otherwise the oldest one.
+		sort.SliceStable(group, func(i, j int) bool {
+			if (group[i].ContentHash != "") != (group[j].ContentHash != "") {
+				return group[i].ContentHash != ""
+			}
+			return group[i].ProcessedAt.Before(group[j].ProcessedAt)
+		})
+		keeper, duplicates := group[0], group[1:]
+
+		for _, dup := range duplicates {
+			log.Printf("duplicate %s (%s) of %s", dup.ImageUUID, dup.FileName, keeper.ImageUUID)
+		}
+		if *dryRun {
+			removed += len(duplicates)
+			continue
+		}
+
+		if keeper.ContentHash == "" {
+			if err := h.images.SetContentHash(ctx, keeper.ImageUUID, hash); err != nil {
+				log.Fatalf("images.SetContentHash failed: %v", err)
+			}
+		}
+
+		for _, dup := range duplicates {
+			// Carry the tags over so no categorization is lost.
+			if len(dup.Tags) > 0 {
+				if _, err := h.images.AddTags(ctx, keeper.ImageUUID, dup.Tags); err != nil {
+					log.Fatalf("images.AddTags failed: %v", err)
+				}
+			}
+
+			// Repeated ingests of one key share the object with the keeper,
+			// and deleted images may still point at it until they are purged.
+			shared, err := h.objectShared(ctx, dup)
+			if err != nil {
+				log.Fatalf("objectShared failed: %v", err)
+			}
+			if err := h.purgeImage(ctx, dup, !shared); err != nil {
+				log.Fatalf("purgeImage failed: %v", err)
+			}
+			removed++
+		}
+	}
+
+	if *dryRun {
+		log.Printf("Found %d duplicate images in %d groups (dry run)", removed, len(groups))
+		return
+	}
+	log.Printf("Removed %d duplicate images, %d unique images remain", removed, len(groups))
+}
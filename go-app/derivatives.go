@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/image/draw"
+)
+
+// derivativePrefix is the object key prefix under which derivatives are stored.
+const derivativePrefix = "derivatives/"
+
+// Derivative is a resized copy of an image, such as a thumbnail.
+type Derivative struct {
+	// ImageUUID is the ID of the original image.
+	ImageUUID string `json:"imageUuid"`
+
+	// Size is the configured bounding box in pixels.
+	Size int `json:"size"`
+
+	// Mode is how the image was fitted into the box: fit or fill.
+	Mode string `json:"mode"`
+
+	// ObjectKey is the key of the derivative in the object store.
+	ObjectKey string `json:"objectKey"`
+
+	// Width of the derivative in pixels.
+	Width int `json:"width"`
+
+	// Height of the derivative in pixels.
+	Height int `json:"height"`
+
+	// FileSize is the size of the encoded derivative in bytes.
+	FileSize int64 `json:"fileSize"`
+
+	// ContentType is the MIME type of the encoded derivative.
+	ContentType string `json:"contentType"`
+
+	// CreatedAt is when the derivative was generated.
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// validateDerivatives checks the derivative config for unknown modes and duplicate sizes.
+func validateDerivatives(cfgs []DerivativeConfig) error {
+	sizes := make(map[int]bool)
+	for _, d := range cfgs {
+		if d.Size <= 0 {
+			return fmt.Errorf("derivative size must be positive, got %d", d.Size)
+		}
+		if d.Mode != "fit" && d.Mode != "fill" {
+			return fmt.Errorf("derivative mode must be fit or fill, got %q", d.Mode)
+		}
+		if sizes[d.Size] {
+			return fmt.Errorf("duplicate derivative size %d", d.Size)
+		}
+		sizes[d.Size] = true
+	}
+
+	return nil
+}
+
+// generateDerivatives renders every configured derivative of the decoded image,
+// writes it to the object store and records it in the repository.
+func generateDerivatives(ctx context.Context, store ObjectStore, repo DerivativeRepository, cfgs []DerivativeConfig, img *Image, src image.Image, m *metrics) error {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "derivatives")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	for _, cfg := range cfgs {
+		dst := resize(src, cfg.Size, cfg.Mode)
+
+		// Keep PNG for formats that may be transparent, JPEG for everything else.
+		var buf bytes.Buffer
+		contentType, ext := "image/jpeg", "jpg"
+		if img.ContentType == "image/png" || img.ContentType == "image/gif" || img.ContentType == "image/webp" {
+			contentType, ext = "image/png", "png"
+			if err := png.Encode(&buf, dst); err != nil {
+				return fmt.Errorf("png.Encode failed: %w", err)
+			}
+		} else if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85}); err != nil {
+			return fmt.Errorf("jpeg.Encode failed: %w", err)
+		}
+
+		d := &Derivative{
+			ImageUUID:   img.ImageUUID,
+			Size:        cfg.Size,
+			Mode:        cfg.Mode,
+			ObjectKey:   fmt.Sprintf("%s%s/%d_%s.%s", derivativePrefix, img.ImageUUID, cfg.Size, cfg.Mode, ext),
+			Width:       dst.Bounds().Dx(),
+			Height:      dst.Bounds().Dy(),
+			FileSize:    int64(buf.Len()),
+			ContentType: contentType,
+			CreatedAt:   time.Now(),
+		}
+
+		if err := store.Put(ctx, d.ObjectKey, &buf, d.ContentType); err != nil {
+			return fmt.Errorf("store.Put failed: %w", err)
+		}
+		if err := repo.Upsert(ctx, d); err != nil {
+			return fmt.Errorf("derivatives.Upsert failed: %w", err)
+		}
+	}
+
+	// Record the duration of the derivative generation.
+	m.duration.With(prometheus.Labels{"op": "derivatives"}).Observe(time.Since(now).Seconds())
+
+	return nil
+}
+
+// derive generates the configured derivatives of a processed image. Failures are
+// only logged because the original image is still usable without them.
+func (h *handler) derive(ctx context.Context, img *Image, src image.Image) {
+	if src == nil || len(h.config.Derivatives) == 0 {
+		return
+	}
+
+	err := generateDerivatives(ctx, h.store, h.derivatives, h.config.Derivatives, img, src, h.metrics)
+	if err != nil {
+		log.Printf("generateDerivatives failed for %s: %v", img.ImageUUID, err)
+	}
+}
+
+// resize scales the image into a size x size box. In fit mode the whole image is
+// kept and the aspect ratio preserved; in fill mode the box is covered and the
+// overflowing edges are cropped. Images are never scaled up.
+func resize(src image.Image, size int, mode string) image.Image {
+	sb := src.Bounds()
+	w, h := sb.Dx(), sb.Dy()
+
+	var srcRect image.Rectangle
+	var dw, dh int
+	if mode == "fill" {
+		// Crop the centered square and scale it down to the box.
+		side := min(w, h)
+		x0 := sb.Min.X + (w-side)/2
+		y0 := sb.Min.Y + (h-side)/2
+		srcRect = image.Rect(x0, y0, x0+side, y0+side)
+		dw = min(size, side)
+		dh = dw
+	} else {
+		// Scale the longest side down to the box.
+		srcRect = sb
+		dw, dh = w, h
+		if w > size || h > size {
+			if w >= h {
+				dw, dh = size, max(1, h*size/w)
+			} else {
+				dw, dh = max(1, w*size/h), size
+			}
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, srcRect, draw.Over, nil)
+
+	return dst
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// getDevices responds with the registered devices matching the query filters as JSON.
+func (h *handler) getDevices(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP GET /api/devices")
+	defer span.End()
+
+	// Record metrics for this operation
+	start := time.Now()
+	defer func() {
+		h.metrics.duration.With(prometheus.Labels{"op": "devices"}).Observe(time.Since(start).Seconds())
+	}()
+
+	f, err := deviceFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	devices, err := h.devices.List(ctx, f)
+	if err != nil {
+		log.Printf("devices.List failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, devices)
+}
+
+// FirmwareCount is the number of devices running a firmware version.
+type FirmwareCount struct {
+	// Firmware version as reported by the devices
+	Firmware string `json:"firmware"`
+
+	// Count of devices running the version
+	Count int `json:"count"`
+
+	// Online counts the devices among them that are online
+	Online int `json:"online"`
+}
+
+// getFirmwareReport responds with a histogram of the firmware versions of the
+// devices matching the query filters, oldest version first. Versions that are
+// not semantic versions come last.
+func (h *handler) getFirmwareReport(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP GET /api/devices/firmware-report")
+	defer span.End()
+
+	f, err := deviceFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	devices, err := h.devices.List(ctx, f)
+	if err != nil {
+		log.Printf("devices.List failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	counts := make(map[string]*FirmwareCount)
+	versions := []*FirmwareCount{}
+	for _, d := range devices {
+		fc, ok := counts[d.Firmware]
+		if !ok {
+			fc = &FirmwareCount{Firmware: d.Firmware}
+			counts[d.Firmware] = fc
+			versions = append(versions, fc)
+		}
+		fc.Count++
+		if d.Status == DeviceOnline {
+			fc.Online++
+		}
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		a, aOK := parseSemver(versions[i].Firmware)
+		b, bOK := parseSemver(versions[j].Firmware)
+		if aOK != bOK {
+			return aOK
+		}
+		if n := a.compare(b); aOK && n != 0 {
+			return n < 0
+		}
+		return versions[i].Firmware < versions[j].Firmware
+	})
+
+	c.JSON(http.StatusOK, gin.H{"total": len(devices), "versions": versions})
+}
+
+// getDevice responds with a single device.
+func (h *handler) getDevice(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP GET /api/devices/:uuid")
+	defer span.End()
+
+	id, ok := deviceUUID(c)
+	if !ok {
+		return
+	}
+
+	d, err := h.devices.Get(ctx, id)
+	if errors.Is(err, ErrDeviceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "device not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("devices.Get failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, d)
+}
+
+// createDevice registers a new device under the UUID of the path.
+func (h *handler) createDevice(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP POST /api/devices/:uuid")
+	defer span.End()
+
+	d, ok := bindDevice(c)
+	if !ok {
+		return
+	}
+
+	err := h.devices.Create(ctx, d)
+	if errors.Is(err, ErrDeviceExists) || errors.Is(err, ErrDuplicateMAC) {
+		c.JSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("devices.Create failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, d)
+}
+
+// updateDevice replaces the MAC address and firmware of the device.
+func (h *handler) updateDevice(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP PUT /api/devices/:uuid")
+	defer span.End()
+
+	d, ok := bindDevice(c)
+	if !ok {
+		return
+	}
+
+	err := h.devices.Update(ctx, d)
+	if errors.Is(err, ErrDeviceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "device not found"})
+		return
+	}
+	if errors.Is(err, ErrDuplicateMAC) {
+		c.JSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("devices.Update failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, d)
+}
+
+// deleteDevice removes the device from the registry.
+func (h *handler) deleteDevice(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP DELETE /api/devices/:uuid")
+	defer span.End()
+
+	id, ok := deviceUUID(c)
+	if !ok {
+		return
+	}
+
+	err := h.devices.Delete(ctx, id)
+	if errors.Is(err, ErrDeviceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "device not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("devices.Delete failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	// Free the gauge slots of the device for another one.
+	h.telemetryGauges.forget(id)
+
+	c.JSON(http.StatusOK, gin.H{"message": "deleted", "uuid": id})
+}
+
+// deviceFilter reads the device filters from the query: firmware
+// constraints such as firmware<3.0.0 or firmware>=2.1.5, mac_prefix and
+// uuid_prefix. The constraints are read from the raw query because the
+// operators are part of the parameter name.
+func deviceFilter(c *gin.Context) (DeviceFilter, error) {
+	var f DeviceFilter
+
+	for _, param := range strings.Split(c.Request.URL.RawQuery, "&") {
+		// PathUnescape keeps the + of build metadata, e.g. 3.0.0+build.7.
+		param, err := url.PathUnescape(param)
+		if err != nil || !strings.HasPrefix(param, "firmware") {
+			continue
+		}
+
+		// Accept firmware=<3.0.0 as well as firmware<3.0.0.
+		expr := strings.TrimPrefix(param, "firmware")
+		if len(expr) > 1 && expr[0] == '=' && strings.ContainsAny(expr[1:2], "<>=!") {
+			expr = expr[1:]
+		}
+
+		constraint, ok := parseVersionConstraint(expr)
+		if !ok {
+			return DeviceFilter{}, fmt.Errorf("invalid firmware filter %q: expected e.g. firmware<3.0.0 or firmware>=2.1.5", param)
+		}
+		f.Firmware = append(f.Firmware, constraint)
+	}
+
+	if s := c.Query("mac_prefix"); s != "" {
+		prefix, err := macPrefix(s)
+		if err != nil {
+			return DeviceFilter{}, err
+		}
+		f.MacPrefix = prefix
+	}
+
+	if s := c.Query("uuid_prefix"); s != "" {
+		prefix, err := uuidPrefix(s)
+		if err != nil {
+			return DeviceFilter{}, err
+		}
+		f.UUIDPrefix = prefix
+	}
+
+	return f, nil
+}
+
+// deviceUUID validates the device UUID from the path and returns it in
+// canonical form. It responds with 400 and returns false when it is invalid.
+func deviceUUID(c *gin.Context) (string, bool) {
+	id, err := uuid.Parse(c.Param("uuid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid device uuid"})
+		return "", false
+	}
+
+	return id.String(), true
+}
+
+// bindDevice reads the MAC address and firmware from the body and validates
+// them together with the UUID from the path. It responds with 400 and returns
+// false when the device is invalid.
+func bindDevice(c *gin.Context) (*Device, bool) {
+	var body struct {
+		Mac      string `json:"mac"`
+		Firmware string `json:"firmware"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid request body"})
+		return nil, false
+	}
+
+	d := &Device{UUID: c.Param("uuid"), Mac: body.Mac, Firmware: body.Firmware}
+	if err := d.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return nil, false
+	}
+
+	return d, true
+}
@@ -1,5 +1,31 @@
 package main
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrDeviceNotFound is returned when no device has the requested UUID.
+var ErrDeviceNotFound = errors.New("device not found")
+
+// ErrDeviceExists is returned when a device with the UUID is already registered.
+var ErrDeviceExists = errors.New("device already exists")
+
+// Device statuses maintained by heartbeats and the offline detector.
+const (
+	DeviceOnline  = "online"
+	DeviceOffline = "offline"
+)
+
+// ErrDuplicateMAC is returned when another device already has the MAC address.
+var ErrDuplicateMAC = errors.New("duplicate mac address")
+
 // Device represents hardware device
 type Device struct {
 	// Universally unique identifier
@@ -10,10 +36,140 @@ type Device struct {
 
 	// Firmware version
 	Firmware string `json:"firmware"`
+
+	// Status is online while the device sends heartbeats, offline otherwise
+	Status string `json:"status"`
+
+	// LastSeen is when the last heartbeat arrived
+	LastSeen *time.Time `json:"lastSeen,omitempty"`
+
+	// LastIP is the client address of the last heartbeat
+	LastIP string `json:"lastIp,omitempty"`
+}
+
+// normalize validates the device and rewrites its UUID and MAC address in
+// canonical form: a lowercase UUID and an uppercase, dash-separated EUI-48.
+func (d *Device) normalize() error {
+	id, err := uuid.Parse(d.UUID)
+	if err != nil {
+		return fmt.Errorf("invalid device uuid")
+	}
+	d.UUID = id.String()
+
+	mac, err := net.ParseMAC(d.Mac)
+	if err != nil || len(mac) != 6 {
+		return fmt.Errorf("invalid mac: expected six hex octets, e.g. 5F-33-CC-1F-43-82")
+	}
+	d.Mac = strings.ToUpper(strings.ReplaceAll(mac.String(), ":", "-"))
+
+	d.Firmware = strings.TrimSpace(d.Firmware)
+	if d.Firmware == "" {
+		return fmt.Errorf("firmware is required")
+	}
+
+	return checkFirmware(d.Firmware)
+}
+
+// checkFirmware rejects firmware that is not a version. The firmware becomes a
+// label of the device gauges, so its length and alphabet must be bounded.
+func checkFirmware(firmware string) error {
+	if !firmwareVersionPattern.MatchString(firmware) {
+		return fmt.Errorf("invalid firmware: expected a version such as 2.1.5 of at most 64 characters")
+	}
+
+	return nil
+}
+
+// DeviceFilter narrows down the devices returned by List. Zero values match every device.
+type DeviceFilter struct {
+	// Firmware constraints a device must satisfy, compared as semantic versions
+	Firmware []versionConstraint
+
+	// MacPrefix matches the start of the MAC address, e.g. the vendor OUI 5F-33-CC
+	MacPrefix string
+
+	// UUIDPrefix matches the start of the lowercase UUID
+	UUIDPrefix string
+}
+
+// matches reports whether the device passes the filter.
+func (f DeviceFilter) matches(d *Device) bool {
+	if !strings.HasPrefix(d.Mac, f.MacPrefix) || !strings.HasPrefix(d.UUID, f.UUIDPrefix) {
+		return false
+	}
+	for _, c := range f.Firmware {
+		if !c.matches(d.Firmware) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// macPrefix rewrites a MAC address prefix such as 5f:33:cc or 5F33CC in the
+// uppercase, dash-separated form devices are stored in.
+func macPrefix(s string) (string, error) {
+	hex := strings.ToUpper(strings.NewReplacer(":", "", "-", "", ".", "").Replace(s))
+	if len(hex) > 12 || strings.Trim(hex, "0123456789ABCDEF") != "" {
+		return "", fmt.Errorf("invalid mac_prefix: expected up to six hex octets, e.g. 5F-33-CC")
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(hex); i += 2 {
+		if i > 0 {
+			b.WriteByte('-')
+		}
+		b.WriteString(hex[i:min(i+2, len(hex))])
+	}
+
+	return b.String(), nil
+}
+
+// uuidPrefix validates a UUID prefix such as B0E42FE7-31 and lowercases it.
+func uuidPrefix(s string) (string, error) {
+	s = strings.ToLower(s)
+	if len(s) > 36 || strings.Trim(s, "0123456789abcdef-") != "" {
+		return "", fmt.Errorf("invalid uuid_prefix: expected hex digits and dashes")
+	}
+
+	return s, nil
+}
+
+// DeviceRepository stores the device registry.
+type DeviceRepository interface {
+	// List returns the devices matching the filter ordered by UUID.
+	List(ctx context.Context, f DeviceFilter) ([]*Device, error)
+
+	// Get loads a single device by its UUID.
+	Get(ctx context.Context, id string) (*Device, error)
+
+	// Create registers a new device. It returns ErrDeviceExists or
+	// ErrDuplicateMAC if the UUID or the MAC address is taken.
+	Create(ctx context.Context, d *Device) error
+
+	// Update replaces the MAC address and firmware of the device and loads
+	// the remaining fields into d. It returns ErrDuplicateMAC if another
+	// device has the MAC address.
+	Update(ctx context.Context, d *Device) error
+
+	// Delete removes the device.
+	Delete(ctx context.Context, id string) error
+
+	// Heartbeat marks the device as online and records when and from where
+	// it was seen. A non-empty firmware replaces the stored version.
+	Heartbeat(ctx context.Context, id string, ip string, firmware string) (*Device, error)
+
+	// MarkOffline marks online devices last seen before the time as offline
+	// and returns how many it changed.
+	MarkOffline(ctx context.Context, before time.Time) (int64, error)
+
+	// Seed registers the devices unless the registry was seeded before, so
+	// devices deleted later do not come back. It reports whether it seeded.
+	Seed(ctx context.Context, devices []Device) (bool, error)
 }
 
-// devices returns pseudo connected devices.
-func devices() []Device {
+// fixtureDevices returns the devices the registry is seeded with.
+func fixtureDevices() []Device {
 	return []Device{
 		{UUID: "b0e42fe7-31a5-4894-a441-007e5256afea", Mac: "5F-33-CC-1F-43-82", Firmware: "2.1.6"},
 		{UUID: "0c3242f5-ae1f-4e0c-a31b-5ec93825b3e7", Mac: "EF-2B-C4-F5-D6-34", Firmware: "2.1.5"},
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryDeviceRepository keeps the device registry in memory; it is meant for tests and demos.
+type memoryDeviceRepository struct {
+	mu      sync.RWMutex
+	devices map[string]*Device
+	seeded  bool
+}
+
+// newMemoryDeviceRepository returns an empty in-memory repository.
+func newMemoryDeviceRepository() *memoryDeviceRepository {
+	return &memoryDeviceRepository{devices: make(map[string]*Device)}
+}
+
+// List returns copies of the matching devices ordered by UUID.
+func (r *memoryDeviceRepository) List(ctx context.Context, f DeviceFilter) ([]*Device, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	devices := make([]*Device, 0, len(r.devices))
+	for _, d := range r.devices {
+		if !f.matches(d) {
+			continue
+		}
+		c := *d
+		devices = append(devices, &c)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].UUID < devices[j].UUID })
+
+	return devices, nil
+}
+
+// Get returns a copy of the device.
+func (r *memoryDeviceRepository) Get(ctx context.Context, id string) (*Device, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	d, ok := r.devices[id]
+	if !ok {
+		return nil, ErrDeviceNotFound
+	}
+
+	c := *d
+	return &c, nil
+}
+
+// Create saves a copy of the new device.
+func (r *memoryDeviceRepository) Create(ctx context.Context, d *Device) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.devices[d.UUID]; ok {
+		return ErrDeviceExists
+	}
+	if r.macTaken(d) {
+		return ErrDuplicateMAC
+	}
+
+	d.Status, d.LastSeen, d.LastIP = DeviceOffline, nil, ""
+	c := *d
+	r.devices[d.UUID] = &c
+	return nil
+}
+
+// Update replaces the MAC address and firmware of the device.
+func (r *memoryDeviceRepository) Update(ctx context.Context, d *Device) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.devices[d.UUID]
+	if !ok {
+		return ErrDeviceNotFound
+	}
+	if r.macTaken(d) {
+		return ErrDuplicateMAC
+	}
+
+	stored.Mac = d.Mac
+	stored.Firmware = d.Firmware
+	*d = *stored
+	return nil
+}
+
+// Heartbeat marks the device as online.
+func (r *memoryDeviceRepository) Heartbeat(ctx context.Context, id string, ip string, firmware string) (*Device, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d, ok := r.devices[id]
+	if !ok {
+		return nil, ErrDeviceNotFound
+	}
+
+	now := time.Now()
+	d.Status = DeviceOnline
+	d.LastSeen = &now
+	d.LastIP = ip
+	if firmware != "" {
+		d.Firmware = firmware
+	}
+
+	c := *d
+	return &c, nil
+}
+
+// MarkOffline marks the silent devices as offline.
+func (r *memoryDeviceRepository) MarkOffline(ctx context.Context, before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var n int64
+	for _, d := range r.devices {
+		if d.Status == DeviceOnline && d.LastSeen != nil && d.LastSeen.Before(before) {
+			d.Status = DeviceOffline
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// Delete removes the device.
+func (r *memoryDeviceRepository) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.devices[id]; !ok {
+		return ErrDeviceNotFound
+	}
+
+	delete(r.devices, id)
+	return nil
+}
+
+// Seed saves copies of the devices the first time it is called.
+func (r *memoryDeviceRepository) Seed(ctx context.Context, devices []Device) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.seeded {
+		return false, nil
+	}
+	r.seeded = true
+
+	for _, d := range devices {
+		if _, ok := r.devices[d.UUID]; ok || r.macTaken(&d) {
+			continue
+		}
+		c := d
+		c.Status = DeviceOffline
+		r.devices[d.UUID] = &c
+	}
+
+	return true, nil
+}
+
+// macTaken reports whether another device has the MAC address of d.
+func (r *memoryDeviceRepository) macTaken(d *Device) bool {
+	for _, other := range r.devices {
+		if other.Mac == d.Mac && other.UUID != d.UUID {
+			return true
+		}
+	}
+
+	return false
+}
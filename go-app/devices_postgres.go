@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// deviceColumns lists the go_device columns in the order scanDevice reads them.
+const deviceColumns = `uuid, mac, firmware, status, last_seen, last_ip`
+
+// pgDeviceRepository stores the device registry in Postgres.
+type pgDeviceRepository struct {
+	// Postgres connection pool
+	dbpool *pgxpool.Pool
+
+	// Prometheus metrics
+	metrics *metrics
+}
+
+// newPgDeviceRepository returns a repository backed by the go_device table.
+func newPgDeviceRepository(dbpool *pgxpool.Pool, m *metrics) *pgDeviceRepository {
+	return &pgDeviceRepository{dbpool: dbpool, metrics: m}
+}
+
+// List returns the matching devices ordered by UUID. The prefixes are matched
+// in SQL; firmware constraints are checked on the loaded rows, since
+// semantic versions do not compare as strings.
+func (r *pgDeviceRepository) List(ctx context.Context, f DeviceFilter) ([]*Device, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL SELECT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	// Both prefixes are validated to hex digits and dashes, so they need no LIKE escaping.
+	query := fmt.Sprintf(`SELECT %s FROM go_device WHERE mac LIKE $1 || '%%' AND uuid::text LIKE $2 || '%%'
+		ORDER BY uuid`, deviceColumns)
+
+	rows, err := r.dbpool.Query(ctx, query, f.MacPrefix, f.UUIDPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("dbpool.Query failed: %w", err)
+	}
+
+	rowDevices, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Device, error) {
+		return scanDevice(row)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pgx.CollectRows failed: %w", err)
+	}
+
+	devices := make([]*Device, 0, len(rowDevices))
+	for _, d := range rowDevices {
+		if f.matches(d) {
+			devices = append(devices, d)
+		}
+	}
+
+	// Record the duration of the select query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return devices, nil
+}
+
+// Get loads a single device by its UUID.
+func (r *pgDeviceRepository) Get(ctx context.Context, id string) (*Device, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL SELECT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := fmt.Sprintf(`SELECT %s FROM go_device WHERE uuid = $1`, deviceColumns)
+
+	d, err := scanDevice(r.dbpool.QueryRow(ctx, query, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrDeviceNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dbpool.QueryRow failed: %w", err)
+	}
+
+	// Record the duration of the select query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return d, nil
+}
+
+// Create inserts a new device.
+func (r *pgDeviceRepository) Create(ctx context.Context, d *Device) error {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL INSERT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := fmt.Sprintf(`INSERT INTO go_device (uuid, mac, firmware) VALUES ($1, $2, $3) RETURNING %s`, deviceColumns)
+
+	created, err := scanDevice(r.dbpool.QueryRow(ctx, query, d.UUID, d.Mac, d.Firmware))
+	if err := deviceConflict(err); err != nil {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("dbpool.QueryRow failed: %w", err)
+	}
+	*d = *created
+
+	// Record the duration of the insert query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return nil
+}
+
+// Update replaces the MAC address and firmware of the device.
+func (r *pgDeviceRepository) Update(ctx context.Context, d *Device) error {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL UPDATE")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := fmt.Sprintf(`UPDATE go_device SET mac = $2, firmware = $3, updated_at = now() WHERE uuid = $1
+		RETURNING %s`, deviceColumns)
+
+	updated, err := scanDevice(r.dbpool.QueryRow(ctx, query, d.UUID, d.Mac, d.Firmware))
+	if err := deviceConflict(err); err != nil {
+		return err
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrDeviceNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("dbpool.QueryRow failed: %w", err)
+	}
+	*d = *updated
+
+	// Record the duration of the update query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return nil
+}
+
+// Delete removes the device.
+func (r *pgDeviceRepository) Delete(ctx context.Context, id string) error {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL DELETE")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	tag, err := r.dbpool.Exec(ctx, `DELETE FROM go_device WHERE uuid = $1`, id)
+	if err != nil {
+		return fmt.Errorf("dbpool.Exec failed: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrDeviceNotFound
+	}
+
+	// Record the duration of the delete query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return nil
+}
+
+// Heartbeat marks the device as online.
+func (r *pgDeviceRepository) Heartbeat(ctx context.Context, id string, ip string, firmware string) (*Device, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL UPDATE")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := fmt.Sprintf(`UPDATE go_device SET status = $2, last_seen = now(), last_ip = $3,
+		firmware = COALESCE(NULLIF($4, ''), firmware) WHERE uuid = $1 RETURNING %s`, deviceColumns)
+
+	d, err := scanDevice(r.dbpool.QueryRow(ctx, query, id, DeviceOnline, ip, firmware))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrDeviceNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dbpool.QueryRow failed: %w", err)
+	}
+
+	// Record the duration of the update query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return d, nil
+}
+
+// MarkOffline marks the silent devices as offline.
+func (r *pgDeviceRepository) MarkOffline(ctx context.Context, before time.Time) (int64, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL UPDATE")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := `UPDATE go_device SET status = $1 WHERE status = $2 AND last_seen < $3`
+
+	tag, err := r.dbpool.Exec(ctx, query, DeviceOffline, DeviceOnline, before)
+	if err != nil {
+		return 0, fmt.Errorf("dbpool.Exec failed: %w", err)
+	}
+
+	// Record the duration of the update query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return tag.RowsAffected(), nil
+}
+
+// Seed inserts the devices in one transaction with the seed marker, skipping
+// devices whose UUID or MAC address is already registered.
+func (r *pgDeviceRepository) Seed(ctx context.Context, devices []Device) (bool, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL INSERT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	seeded := false
+	err := pgx.BeginFunc(ctx, r.dbpool, func(tx pgx.Tx) error {
+		// Concurrent replicas block on the marker row; only the first one seeds.
+		tag, err := tx.Exec(ctx, `INSERT INTO go_device_seed (name) VALUES ('fixtures') ON CONFLICT DO NOTHING`)
+		if err != nil || tag.RowsAffected() == 0 {
+			return err
+		}
+
+		for _, d := range devices {
+			_, err := tx.Exec(ctx, `INSERT INTO go_device (uuid, mac, firmware) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`,
+				d.UUID, d.Mac, d.Firmware)
+			if err != nil {
+				return err
+			}
+		}
+
+		seeded = true
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("pgx.BeginFunc failed: %w", err)
+	}
+
+	// Record the duration of the insert queries.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return seeded, nil
+}
+
+// deviceConflict maps unique violations of go_device to ErrDeviceExists and
+// ErrDuplicateMAC. It returns nil for any other error.
+func deviceConflict(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "23505" {
+		return nil
+	}
+
+	if pgErr.ConstraintName == "go_device_mac_key" {
+		return ErrDuplicateMAC
+	}
+	return ErrDeviceExists
+}
+
+// scanDevice reads a single go_device row selected with deviceColumns.
+func scanDevice(row pgx.Row) (*Device, error) {
+	var d Device
+	if err := row.Scan(&d.UUID, &d.Mac, &d.Firmware, &d.Status, &d.LastSeen, &d.LastIP); err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestMACPrefix(t *testing.T) {
+	tests := []struct {
+		prefix  string
+		want    string
+		wantErr bool
+	}{
+		{"5F-33-CC", "5F-33-CC", false},
+		{"5f:33:cc", "5F-33-CC", false},
+		{"5f33.cc", "5F-33-CC", false},
+		{"5F-33-C", "5F-33-C", false},
+		{"5F33CCA0B1D2", "5F-33-CC-A0-B1-D2", false},
+		{"", "", false},
+		{"5F33CCA0B1D2E", "", true},
+		{"5G-33", "", true},
+		{"5F 33", "", true},
+	}
+	for _, tt := range tests {
+		got, err := macPrefix(tt.prefix)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("macPrefix(%q) = %q, %v, want %q, error %t", tt.prefix, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestUUIDPrefix(t *testing.T) {
+	tests := []struct {
+		prefix  string
+		want    string
+		wantErr bool
+	}{
+		{"B0E42FE7-31", "b0e42fe7-31", false},
+		{"b0e42fe7-31a4-4e0b-9c3d-0a1b2c3d4e5f", "b0e42fe7-31a4-4e0b-9c3d-0a1b2c3d4e5f", false},
+		{"", "", false},
+		{"b0e42fe7-31a4-4e0b-9c3d-0a1b2c3d4e5f0", "", true},
+		{"b0e42fg7", "", true},
+		{"b0e4%", "", true},
+	}
+	for _, tt := range tests {
+		got, err := uuidPrefix(tt.prefix)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("uuidPrefix(%q) = %q, %v, want %q, error %t", tt.prefix, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
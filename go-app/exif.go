@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// errNoExif is returned when a JPEG carries no EXIF segment.
+var errNoExif = errors.New("no exif data")
+
+// EXIF tags read by parseJPEGExif.
+const (
+	tagMake               = 0x010f
+	tagModel              = 0x0110
+	tagOrientation        = 0x0112
+	tagDateTime           = 0x0132
+	tagExifIFD            = 0x8769
+	tagGPSIFD             = 0x8825
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+	tagGPSLatitudeRef     = 0x0001
+	tagGPSLatitude        = 0x0002
+	tagGPSLongitudeRef    = 0x0003
+	tagGPSLongitude       = 0x0004
+)
+
+// exifData is the part of the EXIF metadata kept with the image.
+type exifData struct {
+	// TakenAt is the capture time, or nil if the camera did not record it.
+	TakenAt *time.Time
+
+	// Make and Model identify the camera or device.
+	Make  string
+	Model string
+
+	// Orientation is the EXIF orientation from 1 to 8, or 0 if unknown.
+	Orientation int
+
+	// Latitude and Longitude are the GPS position in decimal degrees.
+	Latitude  *float64
+	Longitude *float64
+}
+
+// parseJPEGExif finds the APP1 Exif segment among the JPEG markers before the
+// image data and parses it. Only the header of the file is needed.
+func parseJPEGExif(data []byte) (*exifData, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errors.New("not a jpeg")
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return nil, fmt.Errorf("invalid marker at %d", i)
+		}
+		marker := data[i+1]
+
+		// Fill bytes and markers without a payload.
+		if marker == 0xff {
+			i++
+			continue
+		}
+		if marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) {
+			i += 2
+			continue
+		}
+
+		// Metadata always comes before the frame and the scan.
+		if marker == 0xda || (marker >= 0xc0 && marker <= 0xcf && marker != 0xc4 && marker != 0xc8 && marker != 0xcc) {
+			break
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errors.New("truncated jpeg segment")
+		}
+
+		segment := data[i+4 : end]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return parseTIFF(segment[6:])
+		}
+
+		i = end
+	}
+
+	return nil, errNoExif
+}
+
+// tiffEntry is a single IFD entry.
+type tiffEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// tiff reads IFDs from a TIFF structure with bounds checks on every offset.
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// typeSizes maps the TIFF field types to the size of a single value.
+var typeSizes = map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+
+// parseTIFF parses the TIFF header and reads the tags we keep.
+func parseTIFF(data []byte) (*exifData, error) {
+	if len(data) < 8 {
+		return nil, errors.New("truncated tiff header")
+	}
+
+	t := &tiff{data: data}
+	switch string(data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, errors.New("invalid tiff byte order")
+	}
+	if t.order.Uint16(data[2:]) != 42 {
+		return nil, errors.New("invalid tiff magic")
+	}
+
+	ifd0, err := t.ifd(t.order.Uint32(data[4:]))
+	if err != nil {
+		return nil, err
+	}
+
+	e := &exifData{
+		Make:        t.ascii(ifd0[tagMake]),
+		Model:       t.ascii(ifd0[tagModel]),
+		Orientation: t.uint(ifd0[tagOrientation]),
+	}
+	if e.Orientation < 1 || e.Orientation > 8 {
+		e.Orientation = 0
+	}
+
+	// Prefer the original capture time from the Exif IFD over the file change time.
+	taken, offset := t.ascii(ifd0[tagDateTime]), ""
+	if ptr, ok := ifd0[tagExifIFD]; ok {
+		if sub, err := t.ifd(uint32(t.uint(ptr))); err == nil {
+			if v := t.ascii(sub[tagDateTimeOriginal]); v != "" {
+				taken = v
+			}
+			offset = t.ascii(sub[tagOffsetTimeOriginal])
+		}
+	}
+	e.TakenAt = exifTime(taken, offset)
+
+	if ptr, ok := ifd0[tagGPSIFD]; ok {
+		if gps, err := t.ifd(uint32(t.uint(ptr))); err == nil {
+			e.Latitude = t.coordinate(gps[tagGPSLatitude], t.ascii(gps[tagGPSLatitudeRef]), "S", 90)
+			e.Longitude = t.coordinate(gps[tagGPSLongitude], t.ascii(gps[tagGPSLongitudeRef]), "W", 180)
+		}
+	}
+
+	// A position needs both coordinates.
+	if e.Latitude == nil || e.Longitude == nil {
+		e.Latitude, e.Longitude = nil, nil
+	}
+
+	return e, nil
+}
+
+// ifd reads the entries of the IFD at the offset.
+func (t *tiff) ifd(off uint32) (map[uint16]tiffEntry, error) {
+	if uint64(off)+2 > uint64(len(t.data)) {
+		return nil, errors.New("ifd offset out of range")
+	}
+
+	n := uint32(t.order.Uint16(t.data[off:]))
+	start := uint64(off) + 2
+	if start+uint64(n)*12 > uint64(len(t.data)) {
+		return nil, errors.New("truncated ifd")
+	}
+
+	entries := make(map[uint16]tiffEntry, n)
+	for i := uint32(0); i < n; i++ {
+		raw := t.data[start+uint64(i)*12:]
+		tag, typ, count := t.order.Uint16(raw), t.order.Uint16(raw[2:]), t.order.Uint32(raw[4:])
+
+		size, ok := typeSizes[typ]
+		if !ok {
+			continue
+		}
+
+		// Values up to four bytes are stored in the entry itself.
+		total := uint64(size) * uint64(count)
+		var value []byte
+		if total <= 4 {
+			value = raw[8 : 8+total]
+		} else {
+			at := uint64(t.order.Uint32(raw[8:]))
+			if at+total > uint64(len(t.data)) {
+				continue
+			}
+			value = t.data[at : at+total]
+		}
+
+		entries[tag] = tiffEntry{typ: typ, count: count, value: value}
+	}
+
+	return entries, nil
+}
+
+// ascii returns an ASCII value without the trailing NUL and padding.
+func (t *tiff) ascii(e tiffEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.TrimRight(string(e.value), "\x00"))
+}
+
+// uint returns the first SHORT or LONG value, or 0.
+func (t *tiff) uint(e tiffEntry) int {
+	switch {
+	case e.typ == 3 && len(e.value) >= 2:
+		return int(t.order.Uint16(e.value))
+	case e.typ == 4 && len(e.value) >= 4:
+		return int(t.order.Uint32(e.value))
+	}
+
+	return 0
+}
+
+// coordinate converts a degrees, minutes, seconds triple of RATIONALs into
+// decimal degrees, negative for the given hemisphere reference.
+func (t *tiff) coordinate(e tiffEntry, ref, negative string, limit float64) *float64 {
+	if e.typ != 5 || len(e.value) < 24 {
+		return nil
+	}
+
+	var dms [3]float64
+	for i := range dms {
+		num, den := t.order.Uint32(e.value[i*8:]), t.order.Uint32(e.value[i*8+4:])
+		if den == 0 {
+			return nil
+		}
+		dms[i] = float64(num) / float64(den)
+	}
+
+	v := dms[0] + dms[1]/60 + dms[2]/3600
+	if strings.EqualFold(ref, negative) {
+		v = -v
+	}
+	if math.IsNaN(v) || math.Abs(v) > limit {
+		return nil
+	}
+
+	return &v
+}
+
+// exifTime parses an EXIF timestamp such as "2024:05:01 13:45:10". Without an
+// offset tag the camera's local time is stored as UTC.
+func exifTime(value, offset string) *time.Time {
+	if value == "" {
+		return nil
+	}
+
+	loc := time.UTC
+	if o, err := time.Parse("-07:00", offset); err == nil {
+		_, secs := o.Zone()
+		loc = time.FixedZone(offset, secs)
+	}
+
+	t, err := time.ParseInLocation("2006:01:02 15:04:05", value, loc)
+	if err != nil || t.Year() < 1900 {
+		return nil
+	}
+
+	return &t
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"math"
+	"testing"
+	"time"
+)
+
+// testEntry is an IFD entry written by appendIFD.
+type testEntry struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte
+}
+
+// appendIFD writes an IFD with its out-of-line values at the end of buf and
+// returns the offset of the IFD.
+func appendIFD(buf []byte, order binary.ByteOrder, entries []testEntry) ([]byte, uint32) {
+	off := uint32(len(buf))
+	dataAt := off + 2 + uint32(len(entries))*12 + 4
+
+	ifd := make([]byte, dataAt-off)
+	order.PutUint16(ifd, uint16(len(entries)))
+	var data []byte
+	for i, e := range entries {
+		raw := ifd[2+i*12:]
+		order.PutUint16(raw, e.tag)
+		order.PutUint16(raw[2:], e.typ)
+		order.PutUint32(raw[4:], e.count)
+		if len(e.value) <= 4 {
+			copy(raw[8:], e.value)
+		} else {
+			order.PutUint32(raw[8:], dataAt+uint32(len(data)))
+			data = append(data, e.value...)
+		}
+	}
+
+	return append(append(buf, ifd...), data...), off
+}
+
+func asciiEntry(tag uint16, s string) testEntry {
+	return testEntry{tag: tag, typ: 2, count: uint32(len(s) + 1), value: append([]byte(s), 0)}
+}
+
+func shortEntry(order binary.ByteOrder, tag uint16, v uint16) testEntry {
+	value := make([]byte, 2)
+	order.PutUint16(value, v)
+	return testEntry{tag: tag, typ: 3, count: 1, value: value}
+}
+
+func longEntry(order binary.ByteOrder, tag uint16, v uint32) testEntry {
+	value := make([]byte, 4)
+	order.PutUint32(value, v)
+	return testEntry{tag: tag, typ: 4, count: 1, value: value}
+}
+
+// rationalEntry holds numerator and denominator pairs.
+func rationalEntry(order binary.ByteOrder, tag uint16, v ...uint32) testEntry {
+	value := make([]byte, 4*len(v))
+	for i, n := range v {
+		order.PutUint32(value[i*4:], n)
+	}
+	return testEntry{tag: tag, typ: 5, count: uint32(len(v) / 2), value: value}
+}
+
+// testTIFF builds the EXIF block of a photo taken in Paris, with the GPS
+// references given.
+func testTIFF(order binary.ByteOrder, latRef, lonRef string) []byte {
+	buf := make([]byte, 8)
+	if order == binary.LittleEndian {
+		copy(buf, "II")
+	} else {
+		copy(buf, "MM")
+	}
+	order.PutUint16(buf[2:], 42)
+
+	buf, exifIFD := appendIFD(buf, order, []testEntry{
+		asciiEntry(tagDateTimeOriginal, "2024:05:01 13:45:10"),
+		asciiEntry(tagOffsetTimeOriginal, "+02:00"),
+	})
+	buf, gpsIFD := appendIFD(buf, order, []testEntry{
+		asciiEntry(tagGPSLatitudeRef, latRef),
+		rationalEntry(order, tagGPSLatitude, 48, 1, 51, 1, 2964, 100),
+		asciiEntry(tagGPSLongitudeRef, lonRef),
+		rationalEntry(order, tagGPSLongitude, 2, 1, 21, 1, 720, 100),
+	})
+	buf, ifd0 := appendIFD(buf, order, []testEntry{
+		asciiEntry(tagMake, "Canon"),
+		asciiEntry(tagModel, "EOS R5"),
+		shortEntry(order, tagOrientation, 6),
+		asciiEntry(tagDateTime, "2024:06:01 08:00:00"),
+		longEntry(order, tagExifIFD, exifIFD),
+		longEntry(order, tagGPSIFD, gpsIFD),
+	})
+	order.PutUint32(buf[4:], ifd0)
+
+	return buf
+}
+
+// testJPEG returns a small valid JPEG carrying the TIFF block in an APP1 segment.
+func testJPEG(t *testing.T, tiff []byte) []byte {
+	t.Helper()
+
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatalf("jpeg.Encode failed: %v", err)
+	}
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+
+	out := []byte{0xff, 0xd8}
+	out = append(out, segment...)
+	out = append(out, payload...)
+	return append(out, img.Bytes()[2:]...)
+}
+
+func TestParseJPEGExif(t *testing.T) {
+	const lat, lon = 48 + 51.0/60 + 29.64/3600, 2 + 21.0/60 + 7.2/3600
+
+	tests := []struct {
+		name           string
+		order          binary.ByteOrder
+		latRef, lonRef string
+		wantLat        float64
+		wantLon        float64
+	}{
+		{"big endian north east", binary.BigEndian, "N", "E", lat, lon},
+		{"little endian north east", binary.LittleEndian, "N", "E", lat, lon},
+		{"south west", binary.BigEndian, "S", "W", -lat, -lon},
+		{"lowercase south west", binary.LittleEndian, "s", "w", -lat, -lon},
+		{"south east", binary.BigEndian, "S", "E", -lat, lon},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := testJPEG(t, testTIFF(tt.order, tt.latRef, tt.lonRef))
+			if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+				t.Fatalf("fixture is not a valid jpeg: %v", err)
+			}
+
+			e, err := parseJPEGExif(data)
+			if err != nil {
+				t.Fatalf("parseJPEGExif failed: %v", err)
+			}
+			if e.Make != "Canon" || e.Model != "EOS R5" || e.Orientation != 6 {
+				t.Errorf("got %q %q orientation %d, want Canon EOS R5 orientation 6", e.Make, e.Model, e.Orientation)
+			}
+
+			want := time.Date(2024, 5, 1, 11, 45, 10, 0, time.UTC)
+			if e.TakenAt == nil || !e.TakenAt.Equal(want) {
+				t.Errorf("got taken at %v, want %v", e.TakenAt, want)
+			}
+
+			if e.Latitude == nil || e.Longitude == nil {
+				t.Fatalf("got no position")
+			}
+			if math.Abs(*e.Latitude-tt.wantLat) > 1e-9 || math.Abs(*e.Longitude-tt.wantLon) > 1e-9 {
+				t.Errorf("got %f, %f, want %f, %f", *e.Latitude, *e.Longitude, tt.wantLat, tt.wantLon)
+			}
+		})
+	}
+}
+
+func TestParseJPEGExifMalformed(t *testing.T) {
+	order := binary.BigEndian
+	valid := testTIFF(order, "N", "E")
+
+	// patch returns a copy of the valid TIFF block with bytes replaced at off.
+	patch := func(off int, b ...byte) []byte {
+		tiff := bytes.Clone(valid)
+		copy(tiff[off:], b)
+		return tiff
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not a jpeg", []byte("GIF89a......")},
+		{"invalid marker", []byte{0xff, 0xd8, 0x00, 0xe1, 0x00, 0x10}},
+		{"segment longer than data", []byte{0xff, 0xd8, 0xff, 0xe1, 0xff, 0xff, 'E', 'x'}},
+		{"segment length below two", []byte{0xff, 0xd8, 0xff, 0xe1, 0x00, 0x01, 0x00, 0x00}},
+		{"invalid byte order", testJPEG(t, patch(0, 'X', 'X'))},
+		{"invalid magic", testJPEG(t, patch(2, 0, 43))},
+		{"ifd offset out of range", testJPEG(t, patch(4, 0xff, 0xff, 0xff, 0xf0))},
+		{"truncated tiff header", testJPEG(t, valid[:6])},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseJPEGExif(tt.data); err == nil {
+				t.Errorf("parseJPEGExif succeeded, want an error")
+			}
+
+			// Unreadable metadata is dropped instead of failing the image.
+			image := &Image{}
+			image.setExif(tt.data)
+			if image.CameraMake != "" || image.TakenAt != nil || image.Latitude != nil || image.Orientation != 0 {
+				t.Errorf("got metadata %+v from malformed exif", image)
+			}
+		})
+	}
+
+	t.Run("no exif segment", func(t *testing.T) {
+		var img bytes.Buffer
+		if err := jpeg.Encode(&img, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+			t.Fatalf("jpeg.Encode failed: %v", err)
+		}
+		if _, err := parseJPEGExif(img.Bytes()); !errors.Is(err, errNoExif) {
+			t.Errorf("got error %v, want %v", err, errNoExif)
+		}
+	})
+
+	// Every truncation and every corrupted byte must be handled without a panic.
+	data := testJPEG(t, valid)
+	for n := range len(data) {
+		parseJPEGExif(data[:n])
+	}
+	for i := range len(valid) {
+		for _, b := range []byte{0x00, 0x7f, 0xff} {
+			parseJPEGExif(testJPEG(t, patch(i, b)))
+		}
+	}
+}
+
+func TestParseJPEGExifInvalidGPS(t *testing.T) {
+	order := binary.LittleEndian
+
+	tests := []struct {
+		name    string
+		entries []testEntry
+	}{
+		{"zero denominator", []testEntry{
+			rationalEntry(order, tagGPSLatitude, 48, 0, 51, 1, 0, 1),
+			rationalEntry(order, tagGPSLongitude, 2, 1, 21, 1, 0, 1),
+		}},
+		{"latitude out of range", []testEntry{
+			rationalEntry(order, tagGPSLatitude, 91, 1, 0, 1, 0, 1),
+			rationalEntry(order, tagGPSLongitude, 2, 1, 21, 1, 0, 1),
+		}},
+		{"latitude only", []testEntry{
+			rationalEntry(order, tagGPSLatitude, 48, 1, 51, 1, 0, 1),
+		}},
+		{"wrong type", []testEntry{
+			shortEntry(order, tagGPSLatitude, 48),
+			rationalEntry(order, tagGPSLongitude, 2, 1, 21, 1, 0, 1),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := []byte{'I', 'I', 42, 0, 0, 0, 0, 0}
+			buf, gps := appendIFD(buf, order, tt.entries)
+			buf, ifd0 := appendIFD(buf, order, []testEntry{longEntry(order, tagGPSIFD, gps)})
+			order.PutUint32(buf[4:], ifd0)
+
+			e, err := parseJPEGExif(testJPEG(t, buf))
+			if err != nil {
+				t.Fatalf("parseJPEGExif failed: %v", err)
+			}
+			if e.Latitude != nil || e.Longitude != nil {
+				t.Errorf("got a position from invalid gps data")
+			}
+		})
+	}
+}
+
+func TestExifTime(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		offset string
+		want   string
+	}{
+		{"local time stored as utc", "2024:05:01 13:45:10", "", "2024-05-01T13:45:10Z"},
+		{"with offset", "2024:05:01 13:45:10", "+02:00", "2024-05-01T13:45:10+02:00"},
+		{"negative offset", "2024:05:01 13:45:10", "-05:30", "2024-05-01T13:45:10-05:30"},
+		{"invalid offset ignored", "2024:05:01 13:45:10", "CEST", "2024-05-01T13:45:10Z"},
+		{"empty", "", "", ""},
+		{"placeholder", "0000:00:00 00:00:00", "", ""},
+		{"before 1900", "1850:01:01 00:00:00", "", ""},
+		{"iso format", "2024-05-01T13:45:10", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := exifTime(tt.value, tt.offset)
+			if tt.want == "" {
+				if got != nil {
+					t.Errorf("exifTime(%q, %q) = %v, want nil", tt.value, tt.offset, got)
+				}
+				return
+			}
+			if got == nil || got.Format(time.RFC3339) != tt.want {
+				t.Errorf("exifTime(%q, %q) = %v, want %s", tt.value, tt.offset, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"regexp"
+	"time"
+)
+
+// firmwarePrefix is the key prefix of firmware artifacts in the bucket.
+const firmwarePrefix = "firmware/"
+
+// Campaign states. Active campaigns hand out their version to the targeted
+// devices; paused ones stop doing so until they are resumed.
+const (
+	CampaignActive    = "active"
+	CampaignPaused    = "paused"
+	CampaignAborted   = "aborted"
+	CampaignCompleted = "completed"
+)
+
+// Rollout states of a single device in a campaign.
+const (
+	RolloutPending    = "pending"
+	RolloutInProgress = "in_progress"
+	RolloutSucceeded  = "succeeded"
+	RolloutFailed     = "failed"
+)
+
+// campaignTransitions lists the states a campaign may be moved to by hand,
+// and the states it may come from.
+var campaignTransitions = map[string][]string{
+	CampaignActive:  {CampaignPaused},
+	CampaignPaused:  {CampaignActive},
+	CampaignAborted: {CampaignActive, CampaignPaused},
+}
+
+// ErrReleaseNotFound is returned when no release has the requested version.
+var ErrReleaseNotFound = errors.New("firmware release not found")
+
+// ErrReleaseExists is returned when a release with the version already exists.
+var ErrReleaseExists = errors.New("firmware release already exists")
+
+// ErrCampaignNotFound is returned when no campaign has the requested ID.
+var ErrCampaignNotFound = errors.New("campaign not found")
+
+// ErrCampaignState is returned when a campaign cannot move to the requested state.
+var ErrCampaignState = errors.New("campaign state change not allowed")
+
+// ErrRolloutNotFound is returned when the device is not targeted by the campaign,
+// or, for Target, by any active campaign.
+var ErrRolloutNotFound = errors.New("rollout not found")
+
+// ErrRolloutFinished is returned when the rollout already succeeded or failed.
+var ErrRolloutFinished = errors.New("rollout already finished")
+
+// firmwareVersionPattern restricts versions to characters that are safe in
+// object keys. Versions start with a digit, optionally prefixed with v, so
+// they never clash with the fixed segments of the firmware routes.
+var firmwareVersionPattern = regexp.MustCompile(`^v?[0-9][0-9A-Za-z.+-]{0,63}$`)
+
+// FirmwareRelease is a firmware version with its artifact in the bucket.
+type FirmwareRelease struct {
+	// Version is the unique version of the release, e.g. 3.0.0.
+	Version string `json:"version"`
+
+	// ObjectKey is the key of the artifact in the object store.
+	ObjectKey string `json:"objectKey"`
+
+	// SHA256 is the hex-encoded checksum of the artifact.
+	SHA256 string `json:"sha256"`
+
+	// Size is the size of the artifact in bytes.
+	Size int64 `json:"size"`
+
+	// CreatedAt is when the release was uploaded.
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Campaign rolls a release out to a set of devices.
+type Campaign struct {
+	// ID is assigned when the campaign is created.
+	ID int64 `json:"id"`
+
+	// Version is the release the targeted devices should run.
+	Version string `json:"version"`
+
+	// Percent is the share of the fleet targeted, or 0 if the devices were listed.
+	Percent int `json:"percent"`
+
+	// State is active, paused, aborted or completed.
+	State string `json:"state"`
+
+	// CreatedAt is when the campaign was created.
+	CreatedAt time.Time `json:"createdAt"`
+
+	// UpdatedAt is when the campaign last changed state.
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	// Progress counts the targeted devices by rollout state.
+	Progress map[string]int64 `json:"progress"`
+}
+
+// Rollout is the progress of a single device in a campaign.
+type Rollout struct {
+	// CampaignID is the campaign the device is targeted by.
+	CampaignID int64 `json:"campaignId"`
+
+	// DeviceUUID is the targeted device.
+	DeviceUUID string `json:"deviceUuid"`
+
+	// Version is the release of the campaign.
+	Version string `json:"version"`
+
+	// State is pending, in_progress, succeeded or failed.
+	State string `json:"state"`
+
+	// Error is the failure the device reported.
+	Error string `json:"error,omitempty"`
+
+	// UpdatedAt is when the state last changed.
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// FirmwareRepository stores firmware releases, campaigns and their rollouts.
+type FirmwareRepository interface {
+	// CreateRelease saves a new release. It returns ErrReleaseExists if the
+	// version is taken.
+	CreateRelease(ctx context.Context, r *FirmwareRelease) error
+
+	// GetRelease loads a release by its version.
+	GetRelease(ctx context.Context, version string) (*FirmwareRelease, error)
+
+	// ListReleases returns all releases, newest first.
+	ListReleases(ctx context.Context) ([]*FirmwareRelease, error)
+
+	// CreateCampaign saves a new active campaign with a pending rollout for
+	// every device and fills in its ID, state, timestamps and progress.
+	CreateCampaign(ctx context.Context, c *Campaign, devices []string) error
+
+	// GetCampaign loads a campaign with its progress.
+	GetCampaign(ctx context.Context, id int64) (*Campaign, error)
+
+	// ListCampaigns returns all campaigns with their progress, newest first.
+	ListCampaigns(ctx context.Context) ([]*Campaign, error)
+
+	// SetCampaignState moves the campaign to the state if campaignTransitions
+	// allows it, and returns ErrCampaignState otherwise.
+	SetCampaignState(ctx context.Context, id int64, state string) (*Campaign, error)
+
+	// Rollouts returns the rollouts of the campaign ordered by device.
+	Rollouts(ctx context.Context, campaignID int64) ([]*Rollout, error)
+
+	// Target returns the unfinished rollout of the newest active campaign
+	// that targets the device, or ErrRolloutNotFound.
+	Target(ctx context.Context, deviceUUID string) (*Rollout, error)
+
+	// UpdateRollout moves an unfinished rollout to the state and records the
+	// error the device reported. A campaign whose rollouts have all finished
+	// is completed.
+	UpdateRollout(ctx context.Context, campaignID int64, deviceUUID string, state string, message string) (*Rollout, error)
+}
+
+// rolloutBucket maps the device to a bucket from 0 to 99 for the version. A
+// campaign for a larger share of the same version targets a superset of the
+// devices of a smaller one.
+func rolloutBucket(version, deviceUUID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(version + "/" + deviceUUID))
+	return int(h.Sum32() % 100)
+}
+
+// newCampaignProgress returns rollout counts with every state at zero.
+func newCampaignProgress() map[string]int64 {
+	return map[string]int64{RolloutPending: 0, RolloutInProgress: 0, RolloutSucceeded: 0, RolloutFailed: 0}
+}
+
+// rolloutFinished reports whether the device is done with the rollout.
+func rolloutFinished(state string) bool {
+	return state == RolloutSucceeded || state == RolloutFailed
+}
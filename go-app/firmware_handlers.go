@@ -0,0 +1,531 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// postFirmware uploads a firmware artifact to the bucket and registers it as a release.
+func (h *handler) postFirmware(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP POST /api/firmware")
+	defer span.End()
+
+	version := c.PostForm("version")
+	if !firmwareVersionPattern.MatchString(version) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid version: expected e.g. 3.0.0"})
+		return
+	}
+	checksum := strings.ToLower(c.PostForm("sha256"))
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "file is required"})
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		log.Printf("header.Open failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal error"})
+		return
+	}
+	defer file.Close()
+
+	// A unique key per upload keeps a concurrent upload of the same version
+	// from overwriting the artifact of the release that wins.
+	rel := &FirmwareRelease{
+		Version:   version,
+		ObjectKey: fmt.Sprintf("%s%s/%s/%s", firmwarePrefix, version, uuid.NewString(), artifactName(header.Filename)),
+		Size:      header.Size,
+	}
+
+	// Stream the artifact into the object store, hashing it on the way.
+	hash := sha256.New()
+	err = h.store.Put(ctx, rel.ObjectKey, io.TeeReader(file, hash), "application/octet-stream")
+	if err != nil {
+		log.Printf("store.Put failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal error"})
+		return
+	}
+	rel.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	// Reject artifacts that were corrupted on the way to us.
+	if checksum != "" && checksum != rel.SHA256 {
+		h.deleteArtifact(ctx, rel.ObjectKey)
+		c.JSON(http.StatusBadRequest, gin.H{"message": "sha256 mismatch", "sha256": rel.SHA256})
+		return
+	}
+
+	err = h.firmware.CreateRelease(ctx, rel)
+	if errors.Is(err, ErrReleaseExists) {
+		h.deleteArtifact(ctx, rel.ObjectKey)
+		c.JSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		h.deleteArtifact(ctx, rel.ObjectKey)
+		log.Printf("firmware.CreateRelease failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, rel)
+}
+
+// listFirmware responds with all firmware releases, newest first.
+func (h *handler) listFirmware(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP GET /api/firmware")
+	defer span.End()
+
+	releases, err := h.firmware.ListReleases(ctx)
+	if err != nil {
+		log.Printf("firmware.ListReleases failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, releases)
+}
+
+// getFirmware responds with a single firmware release.
+func (h *handler) getFirmware(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP GET /api/firmware/:version")
+	defer span.End()
+
+	rel, ok := h.release(ctx, c, c.Param("version"))
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, rel)
+}
+
+// getFirmwareArtifact streams the artifact of a firmware release.
+func (h *handler) getFirmwareArtifact(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP GET /api/firmware/:version/artifact")
+	defer span.End()
+
+	rel, ok := h.release(ctx, c, c.Param("version"))
+	if !ok {
+		return
+	}
+
+	// Devices resume interrupted downloads with range requests.
+	err := h.serveObject(ctx, c, rel.ObjectKey, "application/octet-stream", map[string]string{
+		"Content-Disposition": fmt.Sprintf("attachment; filename=%q", path.Base(rel.ObjectKey)),
+		"X-Checksum-Sha256":   rel.SHA256,
+	})
+	if errors.Is(err, ErrObjectNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "firmware artifact not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("serveObject failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal error"})
+		return
+	}
+}
+
+// createCampaign starts rolling a release out to a share of the fleet or to
+// a listed group of devices. Devices already running the version are left out.
+func (h *handler) createCampaign(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP POST /api/firmware/campaigns")
+	defer span.End()
+
+	var body struct {
+		Version string   `json:"version"`
+		Percent int      `json:"percent"`
+		Devices []string `json:"devices"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid request body"})
+		return
+	}
+	if (body.Percent == 0) == (len(body.Devices) == 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "either percent or devices is required"})
+		return
+	}
+	if body.Percent < 0 || body.Percent > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "percent must be between 1 and 100"})
+		return
+	}
+
+	if _, ok := h.release(ctx, c, body.Version); !ok {
+		return
+	}
+
+	devices, err := h.devices.List(ctx, DeviceFilter{})
+	if err != nil {
+		log.Printf("devices.List failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	// Pick the targets now, so devices registered later are not swept in.
+	var targets []string
+	if body.Percent > 0 {
+		for _, d := range devices {
+			if d.Firmware != body.Version && rolloutBucket(body.Version, d.UUID) < body.Percent {
+				targets = append(targets, d.UUID)
+			}
+		}
+	} else {
+		firmware := make(map[string]string, len(devices))
+		for _, d := range devices {
+			firmware[d.UUID] = d.Firmware
+		}
+
+		listed := make(map[string]bool, len(body.Devices))
+		for _, s := range body.Devices {
+			id, err := uuid.Parse(s)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("invalid device uuid %q", s)})
+				return
+			}
+			current, ok := firmware[id.String()]
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("device %s not found", id)})
+				return
+			}
+			if current != body.Version && !listed[id.String()] {
+				listed[id.String()] = true
+				targets = append(targets, id.String())
+			}
+		}
+	}
+	if len(targets) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "no targeted device needs the update"})
+		return
+	}
+
+	campaign := &Campaign{Version: body.Version, Percent: body.Percent}
+	err = h.firmware.CreateCampaign(ctx, campaign, targets)
+	if errors.Is(err, ErrReleaseNotFound) || errors.Is(err, ErrDeviceNotFound) {
+		c.JSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("firmware.CreateCampaign failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, campaign)
+}
+
+// listCampaigns responds with all campaigns and their progress, newest first.
+func (h *handler) listCampaigns(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP GET /api/firmware/campaigns")
+	defer span.End()
+
+	campaigns, err := h.firmware.ListCampaigns(ctx)
+	if err != nil {
+		log.Printf("firmware.ListCampaigns failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, campaigns)
+}
+
+// getCampaign responds with a campaign, its progress and the rollout of every targeted device.
+func (h *handler) getCampaign(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP GET /api/firmware/campaigns/:id")
+	defer span.End()
+
+	id, ok := campaignID(c)
+	if !ok {
+		return
+	}
+
+	campaign, err := h.firmware.GetCampaign(ctx, id)
+	if errors.Is(err, ErrCampaignNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "campaign not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("firmware.GetCampaign failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	rollouts, err := h.firmware.Rollouts(ctx, id)
+	if err != nil {
+		log.Printf("firmware.Rollouts failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"campaign": campaign, "rollouts": rollouts})
+}
+
+// pauseCampaign stops handing out the version of an active campaign.
+func (h *handler) pauseCampaign(c *gin.Context) {
+	h.setCampaignState(c, "HTTP POST /api/firmware/campaigns/:id/pause", CampaignPaused)
+}
+
+// resumeCampaign continues a paused campaign.
+func (h *handler) resumeCampaign(c *gin.Context) {
+	h.setCampaignState(c, "HTTP POST /api/firmware/campaigns/:id/resume", CampaignActive)
+}
+
+// abortCampaign stops a campaign for good. Devices that are already
+// installing may still report their result.
+func (h *handler) abortCampaign(c *gin.Context) {
+	h.setCampaignState(c, "HTTP POST /api/firmware/campaigns/:id/abort", CampaignAborted)
+}
+
+// setCampaignState moves the campaign of the path to the state.
+func (h *handler) setCampaignState(c *gin.Context, spanName string, state string) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, spanName)
+	defer span.End()
+
+	id, ok := campaignID(c)
+	if !ok {
+		return
+	}
+
+	campaign, err := h.firmware.SetCampaignState(ctx, id, state)
+	if errors.Is(err, ErrCampaignNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "campaign not found"})
+		return
+	}
+	if errors.Is(err, ErrCampaignState) {
+		c.JSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("firmware.SetCampaignState failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, campaign)
+}
+
+// getDeviceFirmware tells a polling device which firmware it should run and
+// where to download it. Polling marks the rollout as in progress.
+func (h *handler) getDeviceFirmware(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP GET /api/devices/:uuid/firmware")
+	defer span.End()
+
+	id, ok := deviceUUID(c)
+	if !ok {
+		return
+	}
+
+	d, err := h.devices.Get(ctx, id)
+	if errors.Is(err, ErrDeviceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "device not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("devices.Get failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	rollout, err := h.firmware.Target(ctx, id)
+	if err != nil && !errors.Is(err, ErrRolloutNotFound) {
+		log.Printf("firmware.Target failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	// A device that got the version some other way is done with the rollout.
+	if rollout != nil && rollout.Version == d.Firmware {
+		if _, err := h.updateRollout(ctx, rollout.CampaignID, id, RolloutSucceeded, ""); err != nil {
+			log.Printf("firmware.UpdateRollout failed: %v", err)
+		}
+		rollout = nil
+	}
+	if rollout == nil {
+		c.JSON(http.StatusOK, gin.H{"uuid": id, "version": d.Firmware, "update": false})
+		return
+	}
+
+	rel, ok := h.release(ctx, c, rollout.Version)
+	if !ok {
+		return
+	}
+
+	if rollout.State == RolloutPending {
+		if _, err := h.updateRollout(ctx, rollout.CampaignID, id, RolloutInProgress, ""); err != nil {
+			log.Printf("firmware.UpdateRollout failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+			return
+		}
+	}
+
+	// Hand out a presigned URL when the store can sign one, so the download
+	// bypasses the app.
+	url := fmt.Sprintf("/api/firmware/%s/artifact", rel.Version)
+	if p, ok := h.store.(Presigner); ok {
+		signed, err := p.PresignGet(ctx, rel.ObjectKey, h.presignTTL())
+		if err != nil {
+			log.Printf("PresignGet failed: %v", err)
+		} else {
+			url = signed
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"uuid":       id,
+		"version":    rel.Version,
+		"update":     true,
+		"campaignId": rollout.CampaignID,
+		"sha256":     rel.SHA256,
+		"size":       rel.Size,
+		"url":        url,
+	})
+}
+
+// postDeviceFirmware records the progress a device reports for its rollout.
+// On success the registry is updated with the new firmware version.
+func (h *handler) postDeviceFirmware(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP POST /api/devices/:uuid/firmware")
+	defer span.End()
+
+	id, ok := deviceUUID(c)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		CampaignID int64  `json:"campaignId"`
+		State      string `json:"state"`
+		Error      string `json:"error"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid request body"})
+		return
+	}
+	switch body.State {
+	case RolloutInProgress, RolloutSucceeded:
+		body.Error = ""
+	case RolloutFailed:
+		if body.Error == "" {
+			body.Error = "unknown error"
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"message": "state must be in_progress, succeeded or failed"})
+		return
+	}
+
+	rollout, err := h.updateRollout(ctx, body.CampaignID, id, body.State, body.Error)
+	if errors.Is(err, ErrRolloutNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "device is not part of the campaign"})
+		return
+	}
+	if errors.Is(err, ErrRolloutFinished) {
+		c.JSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("firmware.UpdateRollout failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	if rollout.State == RolloutSucceeded {
+		d, err := h.devices.Get(ctx, id)
+		if err == nil {
+			d.Firmware = rollout.Version
+			err = h.devices.Update(ctx, d)
+		}
+		if err != nil {
+			log.Printf("failed to record firmware %s of device %s: %v", rollout.Version, id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, rollout)
+}
+
+// updateRollout moves the rollout of the device to the state and counts the
+// rollouts that finish.
+func (h *handler) updateRollout(ctx context.Context, campaignID int64, deviceUUID, state, message string) (*Rollout, error) {
+	rollout, err := h.firmware.UpdateRollout(ctx, campaignID, deviceUUID, state, message)
+	if err != nil {
+		return nil, err
+	}
+
+	if rolloutFinished(rollout.State) {
+		h.metrics.firmwareRollouts.With(prometheus.Labels{"state": rollout.State}).Inc()
+	}
+
+	return rollout, nil
+}
+
+// release loads a firmware release. It responds with 404 or 500 and returns
+// false when the release cannot be loaded.
+func (h *handler) release(ctx context.Context, c *gin.Context, version string) (*FirmwareRelease, bool) {
+	rel, err := h.firmware.GetRelease(ctx, version)
+	if errors.Is(err, ErrReleaseNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "firmware release not found"})
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("firmware.GetRelease failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return nil, false
+	}
+
+	return rel, true
+}
+
+// deleteArtifact removes an artifact whose release was not saved.
+func (h *handler) deleteArtifact(ctx context.Context, key string) {
+	if err := h.store.Delete(ctx, key); err != nil {
+		log.Printf("store.Delete failed: %v", err)
+	}
+}
+
+// artifactName returns the base name of an uploaded file for use in its object
+// key, falling back to "artifact" when the name has no usable base.
+func artifactName(fileName string) string {
+	name := filepath.Base(fileName)
+	switch name {
+	case ".", "..", "/":
+		return "artifact"
+	}
+
+	return name
+}
+
+// campaignID parses the campaign ID from the path. It responds with 400 and
+// returns false when it is invalid.
+func campaignID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid campaign id"})
+		return 0, false
+	}
+
+	return id, true
+}
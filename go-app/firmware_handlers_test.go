@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestArtifactName(t *testing.T) {
+	tests := []struct {
+		fileName string
+		want     string
+	}{
+		{"fw-3.0.0.bin", "fw-3.0.0.bin"},
+		{"build/out/fw.bin", "fw.bin"},
+		{"../../fw.bin", "fw.bin"},
+		{"", "artifact"},
+		{".", "artifact"},
+		{"..", "artifact"},
+		{"/", "artifact"},
+		{"out/..", "artifact"},
+	}
+	for _, tt := range tests {
+		if got := artifactName(tt.fileName); got != tt.want {
+			t.Errorf("artifactName(%q) = %q, want %q", tt.fileName, got, tt.want)
+		}
+	}
+}
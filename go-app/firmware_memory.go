@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryFirmwareRepository keeps firmware releases and campaigns in memory; it is meant for tests and demos.
+type memoryFirmwareRepository struct {
+	mu        sync.RWMutex
+	releases  map[string]*FirmwareRelease
+	campaigns []*Campaign
+	rollouts  map[int64][]*Rollout
+}
+
+// newMemoryFirmwareRepository returns an empty in-memory repository.
+func newMemoryFirmwareRepository() *memoryFirmwareRepository {
+	return &memoryFirmwareRepository{
+		releases: make(map[string]*FirmwareRelease),
+		rollouts: make(map[int64][]*Rollout),
+	}
+}
+
+// CreateRelease saves a copy of the new release.
+func (r *memoryFirmwareRepository) CreateRelease(ctx context.Context, rel *FirmwareRelease) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.releases[rel.Version]; ok {
+		return ErrReleaseExists
+	}
+
+	rel.CreatedAt = time.Now()
+	c := *rel
+	r.releases[rel.Version] = &c
+	return nil
+}
+
+// GetRelease returns a copy of the release.
+func (r *memoryFirmwareRepository) GetRelease(ctx context.Context, version string) (*FirmwareRelease, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rel, ok := r.releases[version]
+	if !ok {
+		return nil, ErrReleaseNotFound
+	}
+
+	c := *rel
+	return &c, nil
+}
+
+// ListReleases returns copies of all releases, newest first.
+func (r *memoryFirmwareRepository) ListReleases(ctx context.Context) ([]*FirmwareRelease, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	releases := make([]*FirmwareRelease, 0, len(r.releases))
+	for _, rel := range r.releases {
+		c := *rel
+		releases = append(releases, &c)
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		if !releases[i].CreatedAt.Equal(releases[j].CreatedAt) {
+			return releases[i].CreatedAt.After(releases[j].CreatedAt)
+		}
+		return releases[i].Version < releases[j].Version
+	})
+
+	return releases, nil
+}
+
+// CreateCampaign saves the campaign with a pending rollout for every device.
+func (r *memoryFirmwareRepository) CreateCampaign(ctx context.Context, c *Campaign, devices []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.releases[c.Version]; !ok {
+		return ErrReleaseNotFound
+	}
+
+	now := time.Now()
+	stored := &Campaign{
+		ID:        int64(len(r.campaigns) + 1),
+		Version:   c.Version,
+		Percent:   c.Percent,
+		State:     CampaignActive,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	r.campaigns = append(r.campaigns, stored)
+
+	rollouts := make([]*Rollout, 0, len(devices))
+	for _, id := range devices {
+		rollouts = append(rollouts, &Rollout{CampaignID: stored.ID, DeviceUUID: id, Version: c.Version, State: RolloutPending, UpdatedAt: now})
+	}
+	sort.Slice(rollouts, func(i, j int) bool { return rollouts[i].DeviceUUID < rollouts[j].DeviceUUID })
+	r.rollouts[stored.ID] = rollouts
+
+	*c = *r.withProgress(stored)
+	return nil
+}
+
+// GetCampaign returns a copy of the campaign with its progress.
+func (r *memoryFirmwareRepository) GetCampaign(ctx context.Context, id int64) (*Campaign, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, err := r.campaign(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.withProgress(c), nil
+}
+
+// ListCampaigns returns copies of all campaigns with their progress, newest first.
+func (r *memoryFirmwareRepository) ListCampaigns(ctx context.Context) ([]*Campaign, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	campaigns := make([]*Campaign, 0, len(r.campaigns))
+	for i := len(r.campaigns) - 1; i >= 0; i-- {
+		campaigns = append(campaigns, r.withProgress(r.campaigns[i]))
+	}
+
+	return campaigns, nil
+}
+
+// SetCampaignState moves the campaign to the state if the current state allows it.
+func (r *memoryFirmwareRepository) SetCampaignState(ctx context.Context, id int64, state string) (*Campaign, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, err := r.campaign(id)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(campaignTransitions[state], c.State) {
+		return nil, ErrCampaignState
+	}
+
+	c.State = state
+	c.UpdatedAt = time.Now()
+	return r.withProgress(c), nil
+}
+
+// Rollouts returns copies of the rollouts of the campaign ordered by device.
+func (r *memoryFirmwareRepository) Rollouts(ctx context.Context, campaignID int64) ([]*Rollout, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rollouts := make([]*Rollout, 0, len(r.rollouts[campaignID]))
+	for _, ro := range r.rollouts[campaignID] {
+		c := *ro
+		rollouts = append(rollouts, &c)
+	}
+
+	return rollouts, nil
+}
+
+// Target returns a copy of the unfinished rollout of the newest active campaign targeting the device.
+func (r *memoryFirmwareRepository) Target(ctx context.Context, deviceUUID string) (*Rollout, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for i := len(r.campaigns) - 1; i >= 0; i-- {
+		if r.campaigns[i].State != CampaignActive {
+			continue
+		}
+		ro := r.rollout(r.campaigns[i].ID, deviceUUID)
+		if ro != nil && !rolloutFinished(ro.State) {
+			c := *ro
+			return &c, nil
+		}
+	}
+
+	return nil, ErrRolloutNotFound
+}
+
+// UpdateRollout moves an unfinished rollout to the state and completes the
+// campaign once all its rollouts have finished.
+func (r *memoryFirmwareRepository) UpdateRollout(ctx context.Context, campaignID int64, deviceUUID string, state string, message string) (*Rollout, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ro := r.rollout(campaignID, deviceUUID)
+	if ro == nil {
+		return nil, ErrRolloutNotFound
+	}
+	if rolloutFinished(ro.State) {
+		return nil, ErrRolloutFinished
+	}
+
+	now := time.Now()
+	ro.State = state
+	ro.Error = message
+	ro.UpdatedAt = now
+
+	// Aborted campaigns stay aborted even when the stragglers finish.
+	c, _ := r.campaign(campaignID)
+	if c.State == CampaignActive || c.State == CampaignPaused {
+		done := true
+		for _, other := range r.rollouts[campaignID] {
+			done = done && rolloutFinished(other.State)
+		}
+		if done {
+			c.State = CampaignCompleted
+			c.UpdatedAt = now
+		}
+	}
+
+	copied := *ro
+	return &copied, nil
+}
+
+// campaign returns the stored campaign; the caller must hold the lock.
+func (r *memoryFirmwareRepository) campaign(id int64) (*Campaign, error) {
+	if id < 1 || id > int64(len(r.campaigns)) {
+		return nil, ErrCampaignNotFound
+	}
+
+	return r.campaigns[id-1], nil
+}
+
+// rollout returns the stored rollout of the device, or nil; the caller must hold the lock.
+func (r *memoryFirmwareRepository) rollout(campaignID int64, deviceUUID string) *Rollout {
+	for _, ro := range r.rollouts[campaignID] {
+		if ro.DeviceUUID == deviceUUID {
+			return ro
+		}
+	}
+
+	return nil
+}
+
+// withProgress returns a copy of the campaign with its rollouts counted; the
+// caller must hold the lock.
+func (r *memoryFirmwareRepository) withProgress(c *Campaign) *Campaign {
+	copied := *c
+	copied.Progress = newCampaignProgress()
+	for _, ro := range r.rollouts[c.ID] {
+		copied.Progress[ro.State]++
+	}
+
+	return &copied
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// releaseColumns lists the go_firmware_release columns in the order scanRelease reads them.
+const releaseColumns = `version, object_key, sha256, size, created_at`
+
+// campaignColumns lists the go_firmware_campaign columns in the order scanCampaign reads them.
+const campaignColumns = `id, version, percent, state, created_at, updated_at`
+
+// rolloutColumns lists the go_firmware_rollout columns, joined with the
+// campaign as c, in the order scanRollout reads them.
+const rolloutColumns = `r.campaign_id, r.device_uuid, c.version, r.state, r.error, r.updated_at`
+
+// pgFirmwareRepository stores firmware releases and campaigns in Postgres.
+type pgFirmwareRepository struct {
+	// Postgres connection pool
+	dbpool *pgxpool.Pool
+
+	// Prometheus metrics
+	metrics *metrics
+}
+
+// newPgFirmwareRepository returns a repository backed by the go_firmware_* tables.
+func newPgFirmwareRepository(dbpool *pgxpool.Pool, m *metrics) *pgFirmwareRepository {
+	return &pgFirmwareRepository{dbpool: dbpool, metrics: m}
+}
+
+// CreateRelease inserts a new release.
+func (r *pgFirmwareRepository) CreateRelease(ctx context.Context, rel *FirmwareRelease) error {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL INSERT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := fmt.Sprintf(`INSERT INTO go_firmware_release (version, object_key, sha256, size) VALUES ($1, $2, $3, $4)
+		RETURNING %s`, releaseColumns)
+
+	created, err := scanRelease(r.dbpool.QueryRow(ctx, query, rel.Version, rel.ObjectKey, rel.SHA256, rel.Size))
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrReleaseExists
+	}
+	if err != nil {
+		return fmt.Errorf("dbpool.QueryRow failed: %w", err)
+	}
+	*rel = *created
+
+	// Record the duration of the insert query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return nil
+}
+
+// GetRelease loads a release by its version.
+func (r *pgFirmwareRepository) GetRelease(ctx context.Context, version string) (*FirmwareRelease, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL SELECT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := fmt.Sprintf(`SELECT %s FROM go_firmware_release WHERE version = $1`, releaseColumns)
+
+	rel, err := scanRelease(r.dbpool.QueryRow(ctx, query, version))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrReleaseNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dbpool.QueryRow failed: %w", err)
+	}
+
+	// Record the duration of the select query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return rel, nil
+}
+
+// ListReleases returns all releases, newest first.
+func (r *pgFirmwareRepository) ListReleases(ctx context.Context) ([]*FirmwareRelease, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL SELECT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	rows, err := r.dbpool.Query(ctx, fmt.Sprintf(`SELECT %s FROM go_firmware_release ORDER BY created_at DESC, version`, releaseColumns))
+	if err != nil {
+		return nil, fmt.Errorf("dbpool.Query failed: %w", err)
+	}
+
+	releases, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*FirmwareRelease, error) {
+		return scanRelease(row)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pgx.CollectRows failed: %w", err)
+	}
+
+	// Record the duration of the select query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return releases, nil
+}
+
+// CreateCampaign inserts the campaign and its rollouts in one transaction.
+func (r *pgFirmwareRepository) CreateCampaign(ctx context.Context, c *Campaign, devices []string) error {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL INSERT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	var created *Campaign
+	err := pgx.BeginFunc(ctx, r.dbpool, func(tx pgx.Tx) error {
+		query := fmt.Sprintf(`INSERT INTO go_firmware_campaign (version, percent, state) VALUES ($1, $2, $3)
+			RETURNING %s`, campaignColumns)
+
+		var err error
+		created, err = scanCampaign(tx.QueryRow(ctx, query, c.Version, c.Percent, CampaignActive))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `INSERT INTO go_firmware_rollout (campaign_id, device_uuid)
+			SELECT $1, unnest($2::text[])::uuid`, created.ID, devices)
+		return err
+	})
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+		// The release or a device was deleted after the handler looked it up.
+		if pgErr.ConstraintName == "go_firmware_campaign_version_fkey" {
+			return ErrReleaseNotFound
+		}
+		return ErrDeviceNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("pgx.BeginFunc failed: %w", err)
+	}
+
+	created.Progress[RolloutPending] = int64(len(devices))
+	*c = *created
+
+	// Record the duration of the insert queries.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return nil
+}
+
+// GetCampaign loads a campaign with its progress.
+func (r *pgFirmwareRepository) GetCampaign(ctx context.Context, id int64) (*Campaign, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL SELECT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := fmt.Sprintf(`SELECT %s FROM go_firmware_campaign WHERE id = $1`, campaignColumns)
+
+	c, err := scanCampaign(r.dbpool.QueryRow(ctx, query, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrCampaignNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dbpool.QueryRow failed: %w", err)
+	}
+
+	if err := r.loadProgress(ctx, []*Campaign{c}); err != nil {
+		return nil, err
+	}
+
+	// Record the duration of the select queries.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return c, nil
+}
+
+// ListCampaigns returns all campaigns with their progress, newest first.
+func (r *pgFirmwareRepository) ListCampaigns(ctx context.Context) ([]*Campaign, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL SELECT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	rows, err := r.dbpool.Query(ctx, fmt.Sprintf(`SELECT %s FROM go_firmware_campaign ORDER BY id DESC`, campaignColumns))
+	if err != nil {
+		return nil, fmt.Errorf("dbpool.Query failed: %w", err)
+	}
+
+	campaigns, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Campaign, error) {
+		return scanCampaign(row)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pgx.CollectRows failed: %w", err)
+	}
+
+	if err := r.loadProgress(ctx, campaigns); err != nil {
+		return nil, err
+	}
+
+	// Record the duration of the select queries.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return campaigns, nil
+}
+
+// SetCampaignState moves the campaign to the state if the current state allows it.
+func (r *pgFirmwareRepository) SetCampaignState(ctx context.Context, id int64, state string) (*Campaign, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL UPDATE")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := fmt.Sprintf(`UPDATE go_firmware_campaign SET state = $2, updated_at = now()
+		WHERE id = $1 AND state = ANY($3) RETURNING %s`, campaignColumns)
+
+	c, err := scanCampaign(r.dbpool.QueryRow(ctx, query, id, state, campaignTransitions[state]))
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Tell a missing campaign apart from one in the wrong state.
+		if _, err := r.GetCampaign(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, ErrCampaignState
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dbpool.QueryRow failed: %w", err)
+	}
+
+	if err := r.loadProgress(ctx, []*Campaign{c}); err != nil {
+		return nil, err
+	}
+
+	// Record the duration of the update query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return c, nil
+}
+
+// Rollouts returns the rollouts of the campaign ordered by device.
+func (r *pgFirmwareRepository) Rollouts(ctx context.Context, campaignID int64) ([]*Rollout, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL SELECT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := fmt.Sprintf(`SELECT %s FROM go_firmware_rollout r JOIN go_firmware_campaign c ON c.id = r.campaign_id
+		WHERE r.campaign_id = $1 ORDER BY r.device_uuid`, rolloutColumns)
+
+	rows, err := r.dbpool.Query(ctx, query, campaignID)
+	if err != nil {
+		return nil, fmt.Errorf("dbpool.Query failed: %w", err)
+	}
+
+	rollouts, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Rollout, error) {
+		return scanRollout(row)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pgx.CollectRows failed: %w", err)
+	}
+
+	// Record the duration of the select query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return rollouts, nil
+}
+
+// Target returns the unfinished rollout of the newest active campaign targeting the device.
+func (r *pgFirmwareRepository) Target(ctx context.Context, deviceUUID string) (*Rollout, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL SELECT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := fmt.Sprintf(`SELECT %s FROM go_firmware_rollout r JOIN go_firmware_campaign c ON c.id = r.campaign_id
+		WHERE r.device_uuid = $1 AND r.state IN ($2, $3) AND c.state = $4
+		ORDER BY c.id DESC LIMIT 1`, rolloutColumns)
+
+	ro, err := scanRollout(r.dbpool.QueryRow(ctx, query, deviceUUID, RolloutPending, RolloutInProgress, CampaignActive))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrRolloutNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dbpool.QueryRow failed: %w", err)
+	}
+
+	// Record the duration of the select query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return ro, nil
+}
+
+// UpdateRollout moves an unfinished rollout to the state and completes the
+// campaign in the same transaction once all its rollouts have finished.
+func (r *pgFirmwareRepository) UpdateRollout(ctx context.Context, campaignID int64, deviceUUID string, state string, message string) (*Rollout, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL UPDATE")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	var ro *Rollout
+	err := pgx.BeginFunc(ctx, r.dbpool, func(tx pgx.Tx) error {
+		// Lock the campaign so concurrent reports of its last rollouts do not
+		// both see the other one unfinished and leave the campaign active.
+		if _, err := tx.Exec(ctx, `SELECT id FROM go_firmware_campaign WHERE id = $1 FOR UPDATE`, campaignID); err != nil {
+			return err
+		}
+
+		query := fmt.Sprintf(`UPDATE go_firmware_rollout r SET state = $3, error = $4, updated_at = now()
+			FROM go_firmware_campaign c
+			WHERE c.id = r.campaign_id AND r.campaign_id = $1 AND r.device_uuid = $2 AND r.state IN ($5, $6)
+			RETURNING %s`, rolloutColumns)
+
+		var err error
+		ro, err = scanRollout(tx.QueryRow(ctx, query, campaignID, deviceUUID, state, message, RolloutPending, RolloutInProgress))
+		if errors.Is(err, pgx.ErrNoRows) {
+			var exists bool
+			err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM go_firmware_rollout WHERE campaign_id = $1 AND device_uuid = $2)`,
+				campaignID, deviceUUID).Scan(&exists)
+			if err != nil {
+				return err
+			}
+			if exists {
+				return ErrRolloutFinished
+			}
+			return ErrRolloutNotFound
+		}
+		if err != nil || state == RolloutInProgress {
+			return err
+		}
+
+		// Aborted campaigns stay aborted even when the stragglers finish.
+		_, err = tx.Exec(ctx, `UPDATE go_firmware_campaign SET state = $2, updated_at = now()
+			WHERE id = $1 AND state IN ($3, $4) AND NOT EXISTS (
+				SELECT 1 FROM go_firmware_rollout WHERE campaign_id = $1 AND state IN ($5, $6))`,
+			campaignID, CampaignCompleted, CampaignActive, CampaignPaused, RolloutPending, RolloutInProgress)
+		return err
+	})
+	if errors.Is(err, ErrRolloutFinished) || errors.Is(err, ErrRolloutNotFound) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("pgx.BeginFunc failed: %w", err)
+	}
+
+	// Record the duration of the update queries.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return ro, nil
+}
+
+// loadProgress counts the rollouts of the campaigns by state.
+func (r *pgFirmwareRepository) loadProgress(ctx context.Context, campaigns []*Campaign) error {
+	byID := make(map[int64]*Campaign, len(campaigns))
+	ids := make([]int64, 0, len(campaigns))
+	for _, c := range campaigns {
+		byID[c.ID] = c
+		ids = append(ids, c.ID)
+	}
+
+	rows, err := r.dbpool.Query(ctx, `SELECT campaign_id, state, COUNT(*) FROM go_firmware_rollout
+		WHERE campaign_id = ANY($1) GROUP BY campaign_id, state`, ids)
+	if err != nil {
+		return fmt.Errorf("dbpool.Query failed: %w", err)
+	}
+
+	var (
+		id    int64
+		state string
+		count int64
+	)
+	_, err = pgx.ForEachRow(rows, []any{&id, &state, &count}, func() error {
+		byID[id].Progress[state] = count
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("pgx.ForEachRow failed: %w", err)
+	}
+
+	return nil
+}
+
+// scanRelease reads a single go_firmware_release row selected with releaseColumns.
+func scanRelease(row pgx.Row) (*FirmwareRelease, error) {
+	var r FirmwareRelease
+	if err := row.Scan(&r.Version, &r.ObjectKey, &r.SHA256, &r.Size, &r.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// scanCampaign reads a single go_firmware_campaign row selected with campaignColumns.
+func scanCampaign(row pgx.Row) (*Campaign, error) {
+	c := Campaign{Progress: newCampaignProgress()}
+	if err := row.Scan(&c.ID, &c.Version, &c.Percent, &c.State, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// scanRollout reads a single rollout row selected with rolloutColumns.
+func scanRollout(row pgx.Row) (*Rollout, error) {
+	var r Rollout
+	if err := row.Scan(&r.CampaignID, &r.DeviceUUID, &r.Version, &r.State, &r.Error, &r.UpdatedAt); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// postHeartbeat records that the device is alive, together with its client
+// address and the firmware it reports.
+func (h *handler) postHeartbeat(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP POST /api/devices/:uuid/heartbeat")
+	defer span.End()
+
+	id, ok := deviceUUID(c)
+	if !ok {
+		return
+	}
+
+	// The body is optional; an empty heartbeat keeps the stored firmware.
+	var body struct {
+		Firmware string `json:"firmware"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid request body"})
+			return
+		}
+	}
+
+	firmware := strings.TrimSpace(body.Firmware)
+	if firmware != "" {
+		if err := checkFirmware(firmware); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+	}
+
+	d, err := h.devices.Heartbeat(ctx, id, c.ClientIP(), firmware)
+	if errors.Is(err, ErrDeviceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "device not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("devices.Heartbeat failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, d)
+}
+
+// detectOffline marks the devices that stayed silent for longer than the
+// configured window as offline.
+func (h *handler) detectOffline(ctx context.Context) error {
+	// Create a new ROOT span to record and trace the detection.
+	ctx, span := tracer.Start(ctx, "JOB detect offline devices")
+	defer span.End()
+
+	window := time.Duration(h.config.Devices.OfflineAfterSeconds) * time.Second
+	n, err := h.devices.MarkOffline(ctx, time.Now().Add(-window))
+	if err != nil {
+		return fmt.Errorf("devices.MarkOffline failed: %w", err)
+	}
+	if n > 0 {
+		log.Printf("marked %d devices as offline", n)
+	}
+
+	return nil
+}
+
+// refreshDeviceGauges sets the device gauges from the registry.
+func (h *handler) refreshDeviceGauges(ctx context.Context) error {
+	// Create a new ROOT span to record and trace the refresh.
+	ctx, span := tracer.Start(ctx, "JOB refresh device gauges")
+	defer span.End()
+
+	devices, err := h.devices.List(ctx, DeviceFilter{})
+	if err != nil {
+		return fmt.Errorf("devices.List failed: %w", err)
+	}
+
+	// Count first so the gauges are only briefly empty, and firmware versions
+	// that are no longer in use disappear.
+	counts := make(map[[2]string]int)
+	for _, d := range devices {
+		counts[[2]string{d.Status, d.Firmware}]++
+	}
+	h.metrics.devices.Reset()
+	for k, n := range counts {
+		h.metrics.devices.With(prometheus.Labels{"status": k[0], "firmware": k[1]}).Set(float64(n))
+	}
+
+	return nil
+}
+
+// offlineLoop periodically runs the offline detector until the context is done.
+func (h *handler) offlineLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(h.config.Devices.CheckIntervalSeconds) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		if err := h.detectOffline(ctx); err != nil {
+			log.Printf("detectOffline failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deviceGaugesLoop periodically refreshes the device gauges and expires the
+// telemetry gauges of silent devices until the context is done. Every replica
+// runs it, so each one exports the gauges, whether or not it runs the offline
+// detector.
+func (h *handler) deviceGaugesLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(h.config.Devices.GaugeIntervalSeconds) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		if err := h.refreshDeviceGauges(ctx); err != nil {
+			log.Printf("refreshDeviceGauges failed: %v", err)
+		}
+		h.telemetryGauges.expireSilent(time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
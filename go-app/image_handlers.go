@@ -0,0 +1,660 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultPageSize is used when the client does not set a limit.
+	defaultPageSize = 20
+
+	// maxPageSize caps the number of items returned in a single page.
+	maxPageSize = 100
+
+	// maxTagLength caps the length of a single tag.
+	maxTagLength = 64
+)
+
+// ingestImage queues an image that is already in the object store for processing.
+func (h *handler) ingestImage(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP POST /api/images/ingest")
+	defer span.End()
+
+	// Use thumbnail.png file unless the client asks for another key.
+	fileName := c.DefaultQuery("key", "thumbnail.png")
+
+	// Hash the image to detect duplicates; the worker downloads it again later.
+	info, hash, err := hashObject(ctx, h.store, fileName)
+	if errors.Is(err, ErrObjectNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "object not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("hashObject failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal error"})
+		return
+	}
+
+	// Generate a new image with enhanced metadata.
+	image := newObjectImage(info)
+	image.ContentHash = hash
+
+	// Save the image metadata and queue it for processing.
+	image, duplicate, err := h.ingest(ctx, image)
+	if err != nil {
+		log.Printf("ingest failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	h.audit(ctx, c, auditUpload, image.ImageUUID)
+
+	if duplicate {
+		c.JSON(http.StatusOK, gin.H{"message": "duplicate", "duplicate": true, "metadata": image})
+		return
+	}
+
+	// Return enhanced metadata in response
+	c.JSON(http.StatusAccepted, gin.H{"message": "queued", "duplicate": false, "metadata": image})
+}
+
+// postImage uploads a multipart image to the object store and queues it for processing.
+func (h *handler) postImage(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP POST /api/images")
+	defer span.End()
+
+	// Read the uploaded file from the "file" form field.
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "file is required"})
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		log.Printf("header.Open failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal error"})
+		return
+	}
+	defer file.Close()
+
+	// Generate a new image with enhanced metadata.
+	image := NewImage(filepath.Base(header.Filename), header.Size, time.Now())
+	image.ObjectKey = uploadKey(image)
+
+	// Trust the client content type until the worker sniffs the real one.
+	if ct := header.Header.Get("Content-Type"); ct != "" {
+		image.ContentType = ct
+	}
+
+	// Stream the image into the object store, hashing it on the way.
+	hash := sha256.New()
+	err = h.store.Put(ctx, image.ObjectKey, io.TeeReader(file, hash), image.ContentType)
+	if err != nil {
+		log.Printf("store.Put failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal error"})
+		return
+	}
+	image.ContentHash = hex.EncodeToString(hash.Sum(nil))
+
+	// Save the image metadata and queue it for processing.
+	existing, duplicate, err := h.ingest(ctx, image)
+	if err != nil {
+		log.Printf("ingest failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	if duplicate {
+		// The content is already stored under the existing image's key, unless
+		// a bucket event registered this very object first.
+		if existing.ObjectKey != image.ObjectKey {
+			if err := h.store.Delete(ctx, image.ObjectKey); err != nil {
+				log.Printf("store.Delete failed: %v", err)
+			}
+		}
+		h.audit(ctx, c, auditUpload, existing.ImageUUID)
+		c.JSON(http.StatusOK, gin.H{"message": "duplicate", "duplicate": true, "metadata": existing})
+		return
+	}
+
+	h.audit(ctx, c, auditUpload, image.ImageUUID)
+	c.JSON(http.StatusAccepted, gin.H{"message": "queued", "duplicate": false, "metadata": image})
+}
+
+// listImages responds with a page of images matching the query filters.
+func (h *handler) listImages(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP GET /api/images")
+	defer span.End()
+
+	limit, offset, err := pagination(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	f := ImageFilter{
+		Status:      c.Query("status"),
+		ContentType: c.Query("content_type"),
+		Tag:         c.Query("tag"),
+		Size:        c.Query("size"),
+		Deleted:     c.Query("deleted") == "true",
+		Limit:       limit,
+		Offset:      offset,
+	}
+
+	if f.Size != "" && f.Size != "small" && f.Size != "large" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid size: expected small or large"})
+		return
+	}
+
+	// Parse the optional processed_at range.
+	if f.ProcessedFrom, err = queryTime(c, "processed_from"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if f.ProcessedTo, err = queryTime(c, "processed_to"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// Parse the optional EXIF capture time range and GPS bounding box.
+	if f.TakenFrom, err = queryTime(c, "taken_from"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if f.TakenTo, err = queryTime(c, "taken_to"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if f.Bounds, err = queryBounds(c, "bbox"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	images, err := h.images.List(ctx, f)
+	if err != nil {
+		log.Printf("images.List failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	// Count all matching images to support pagination.
+	total, err := h.images.Count(ctx, f)
+	if err != nil {
+		log.Printf("images.Count failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":  images,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// getImage responds with the image metadata, or with the image itself when content=true.
+func (h *handler) getImage(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP GET /api/images/:uuid")
+	defer span.End()
+
+	id, ok := imageUUID(c)
+	if !ok {
+		return
+	}
+
+	image, err := h.images.Get(ctx, id)
+	if errors.Is(err, ErrImageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "image not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("images.Get failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	if c.Query("content") != "true" {
+		h.audit(ctx, c, auditRead, image.ImageUUID)
+		c.JSON(http.StatusOK, image)
+		return
+	}
+
+	// Stream the image bytes from the object store straight to the client.
+	err = h.serveObject(ctx, c, image.ObjectKey, image.ContentType, map[string]string{
+		"Content-Disposition": fmt.Sprintf("inline; filename=%q", image.FileName),
+	})
+	if errors.Is(err, ErrObjectNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "image content not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("serveObject failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal error"})
+		return
+	}
+
+	h.audit(ctx, c, auditDownload, image.ImageUUID)
+}
+
+// deleteImage tombstones the image. It can be restored until the sweeper
+// purges it together with its objects after the grace period.
+func (h *handler) deleteImage(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP DELETE /api/images/:uuid")
+	defer span.End()
+
+	id, ok := imageUUID(c)
+	if !ok {
+		return
+	}
+
+	image, err := h.images.SoftDelete(ctx, id)
+	if errors.Is(err, ErrImageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "image not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("images.SoftDelete failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	h.audit(ctx, c, auditDelete, image.ImageUUID)
+
+	grace := time.Duration(h.config.Retention.DeletedGraceHours) * time.Hour
+	c.JSON(http.StatusOK, gin.H{"message": "deleted", "uuid": image.ImageUUID, "purgeAfter": image.DeletedAt.Add(grace)})
+}
+
+// restoreImage brings a deleted image back before it is purged.
+func (h *handler) restoreImage(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP POST /api/images/:uuid/restore")
+	defer span.End()
+
+	id, ok := imageUUID(c)
+	if !ok {
+		return
+	}
+
+	image, err := h.images.Restore(ctx, id)
+	if errors.Is(err, ErrImageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "deleted image not found"})
+		return
+	}
+	if errors.Is(err, ErrDuplicateContent) {
+		c.JSON(http.StatusConflict, gin.H{"message": "another image has the same content"})
+		return
+	}
+	if err != nil {
+		log.Printf("images.Restore failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	// A job that ran while the image was deleted gave up on it; queue it again.
+	if image.Status == StatusUploaded || image.Status == StatusProcessing {
+		if err := h.jobs.Enqueue(ctx, id); err != nil {
+			log.Printf("jobs.Enqueue failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+			return
+		}
+	}
+
+	h.audit(ctx, c, auditRestore, image.ImageUUID)
+	c.JSON(http.StatusOK, image)
+}
+
+// purgeImage removes the image row and its derivatives. The original object is
+// removed too unless deleteObject is false, e.g. when another image shares it.
+func (h *handler) purgeImage(ctx context.Context, image *Image, deleteObject bool) error {
+	derivatives, err := h.derivatives.List(ctx, image.ImageUUID)
+	if err != nil {
+		return fmt.Errorf("derivatives.List failed: %w", err)
+	}
+
+	// Remove the objects first so a failure never leaves an orphaned object behind.
+	var keys []string
+	if deleteObject {
+		keys = append(keys, image.ObjectKey)
+	}
+	for _, d := range derivatives {
+		keys = append(keys, d.ObjectKey)
+	}
+	for _, key := range keys {
+		if err := h.store.Delete(ctx, key); err != nil {
+			return fmt.Errorf("store.Delete failed: %w", err)
+		}
+	}
+
+	if err := h.derivatives.Delete(ctx, image.ImageUUID); err != nil {
+		return fmt.Errorf("derivatives.Delete failed: %w", err)
+	}
+
+	err = h.images.Delete(ctx, image.ImageUUID)
+	if err != nil && !errors.Is(err, ErrImageNotFound) {
+		return fmt.Errorf("images.Delete failed: %w", err)
+	}
+
+	return nil
+}
+
+// reprocessImage sends a processed or failed image back to the processing queue.
+func (h *handler) reprocessImage(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP POST /api/images/:uuid/reprocess")
+	defer span.End()
+
+	id, ok := imageUUID(c)
+	if !ok {
+		return
+	}
+
+	err := h.images.UpdateStatus(ctx, id, StatusUploaded, "reprocess requested")
+	if errors.Is(err, ErrImageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "image not found"})
+		return
+	}
+	if errors.Is(err, ErrInvalidTransition) {
+		c.JSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("images.UpdateStatus failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	if err := h.jobs.Enqueue(ctx, id); err != nil {
+		log.Printf("jobs.Enqueue failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	h.audit(ctx, c, auditReprocess, id)
+	c.JSON(http.StatusAccepted, gin.H{"message": "queued", "uuid": id})
+}
+
+// getImageHistory responds with the status transitions of the image.
+func (h *handler) getImageHistory(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP GET /api/images/:uuid/history")
+	defer span.End()
+
+	id, ok := imageUUID(c)
+	if !ok {
+		return
+	}
+
+	// Make sure the image exists to tell it apart from an empty history.
+	if _, err := h.images.Get(ctx, id); errors.Is(err, ErrImageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "image not found"})
+		return
+	} else if err != nil {
+		log.Printf("images.Get failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	events, err := h.images.History(ctx, id)
+	if err != nil {
+		log.Printf("images.History failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	h.audit(ctx, c, auditRead, id)
+	c.JSON(http.StatusOK, events)
+}
+
+// getImageThumbnail streams the derivative of the requested size, the smallest one by default.
+func (h *handler) getImageThumbnail(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP GET /api/images/:uuid/thumbnail")
+	defer span.End()
+
+	id, ok := imageUUID(c)
+	if !ok {
+		return
+	}
+
+	if len(h.config.Derivatives) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": "no derivatives configured"})
+		return
+	}
+
+	// Pick the smallest configured size unless the client asks for one.
+	size := h.config.Derivatives[0].Size
+	for _, d := range h.config.Derivatives {
+		size = min(size, d.Size)
+	}
+	if v := c.Query("size"); v != "" {
+		var err error
+		if size, err = strconv.Atoi(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid size"})
+			return
+		}
+	}
+
+	d, err := h.derivatives.Get(ctx, id, size)
+	if errors.Is(err, ErrDerivativeNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "thumbnail not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("derivatives.Get failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	err = h.serveObject(ctx, c, d.ObjectKey, d.ContentType, nil)
+	if errors.Is(err, ErrObjectNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "thumbnail content not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("serveObject failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal error"})
+		return
+	}
+
+	h.audit(ctx, c, auditDownload, id)
+}
+
+// addImageTags adds the tags from the JSON body to the image.
+func (h *handler) addImageTags(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP POST /api/images/:uuid/tags")
+	defer span.End()
+
+	id, ok := imageUUID(c)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Tags []string `json:"tags"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid request body"})
+		return
+	}
+
+	tags, err := normalizeTags(body.Tags)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	image, err := h.images.AddTags(ctx, id, tags)
+	if errors.Is(err, ErrImageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "image not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("images.AddTags failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	h.audit(ctx, c, auditTag, id)
+	c.JSON(http.StatusOK, image)
+}
+
+// removeImageTag removes a single tag from the image.
+func (h *handler) removeImageTag(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP DELETE /api/images/:uuid/tags/:tag")
+	defer span.End()
+
+	id, ok := imageUUID(c)
+	if !ok {
+		return
+	}
+
+	image, err := h.images.RemoveTags(ctx, id, []string{c.Param("tag")})
+	if errors.Is(err, ErrImageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "image not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("images.RemoveTags failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	h.audit(ctx, c, auditUntag, id)
+	c.JSON(http.StatusOK, image)
+}
+
+// listTags responds with every tag and the number of images carrying it.
+func (h *handler) listTags(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP GET /api/tags")
+	defer span.End()
+
+	counts, err := h.images.TagCounts(ctx)
+	if err != nil {
+		log.Printf("images.TagCounts failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, counts)
+}
+
+// imageUUID returns the :uuid path parameter, responding with 400 when it is not a valid UUID.
+func imageUUID(c *gin.Context) (string, bool) {
+	id := c.Param("uuid")
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid image uuid"})
+		return "", false
+	}
+
+	return id, true
+}
+
+// normalizeTags trims the tags and rejects empty or overly long ones.
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("tags are required")
+	}
+
+	out := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			return nil, fmt.Errorf("tags must not be empty")
+		}
+		if len(tag) > maxTagLength {
+			return nil, fmt.Errorf("tags must be at most %d characters", maxTagLength)
+		}
+		out = append(out, tag)
+	}
+
+	return out, nil
+}
+
+// pagination parses the limit and offset query parameters.
+func pagination(c *gin.Context) (int, int, error) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageSize)))
+	if err != nil || limit < 1 {
+		return 0, 0, fmt.Errorf("invalid limit")
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		return 0, 0, fmt.Errorf("invalid offset")
+	}
+
+	return limit, offset, nil
+}
+
+// queryTime parses an optional RFC 3339 timestamp from the query string.
+func queryTime(c *gin.Context, name string) (*time.Time, error) {
+	v := c.Query(name)
+	if v == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: expected RFC 3339 timestamp", name)
+	}
+
+	return &t, nil
+}
+
+// queryBounds parses an optional "minLon,minLat,maxLon,maxLat" bounding box
+// query parameter, the GeoJSON order.
+func queryBounds(c *gin.Context, name string) (*GeoBounds, error) {
+	v := c.Query(name)
+	if v == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(v, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("invalid %s: expected minLon,minLat,maxLon,maxLat", name)
+	}
+
+	var n [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: expected minLon,minLat,maxLon,maxLat", name)
+		}
+		n[i] = f
+	}
+
+	b := &GeoBounds{MinLon: n[0], MinLat: n[1], MaxLon: n[2], MaxLat: n[3]}
+	if b.MinLat > b.MaxLat || b.MinLat < -90 || b.MaxLat > 90 || math.Abs(b.MinLon) > 180 || math.Abs(b.MaxLon) > 180 {
+		return nil, fmt.Errorf("invalid %s: coordinates out of range", name)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+)
+
+// newTestRouter returns the image routes backed by in-memory repositories.
+func newTestRouter(t *testing.T) (*gin.Engine, *handler) {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	tracer = otel.Tracer("go-app-test")
+
+	h := &handler{
+		config:   &Config{},
+		metrics:  NewMetrics(prometheus.NewRegistry()),
+		store:    newMemoryStore(),
+		images:   newMemoryImageRepository(),
+		jobs:     newMemoryJobQueue(),
+		auditLog: newMemoryAuditLog(),
+	}
+
+	r := gin.New()
+	r.GET("/api/images", h.listImages)
+	r.POST("/api/images", h.postImage)
+	r.GET("/api/images/:uuid", h.getImage)
+	r.DELETE("/api/images/:uuid", h.deleteImage)
+
+	return r, h
+}
+
+// serve records the response of the router to the request.
+func serve(r *gin.Engine, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// insertImage saves an image straight into the repository.
+func insertImage(t *testing.T, h *handler, fileName string) *Image {
+	t.Helper()
+
+	image := NewImage(fileName, 128, time.Now())
+	if err := h.images.Insert(context.Background(), image); err != nil {
+		t.Fatalf("images.Insert failed: %v", err)
+	}
+
+	return image
+}
+
+func TestListImages(t *testing.T) {
+	r, h := newTestRouter(t)
+	insertImage(t, h, "a.png")
+	insertImage(t, h, "b.png")
+
+	w := serve(r, httptest.NewRequest(http.MethodGet, "/api/images?limit=1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	var page struct {
+		Items []Image `json:"items"`
+		Total int64   `json:"total"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	if len(page.Items) != 1 || page.Total != 2 {
+		t.Errorf("got %d items of %d, want 1 of 2", len(page.Items), page.Total)
+	}
+
+	w = serve(r, httptest.NewRequest(http.MethodGet, "/api/images?limit=0", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for limit=0, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestGetImage(t *testing.T) {
+	r, h := newTestRouter(t)
+	image := insertImage(t, h, "a.png")
+
+	tests := []struct {
+		name string
+		id   string
+		want int
+	}{
+		{"existing", image.ImageUUID, http.StatusOK},
+		{"unknown", uuid.New().String(), http.StatusNotFound},
+		{"invalid", "not-a-uuid", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(r, httptest.NewRequest(http.MethodGet, "/api/images/"+tt.id, nil))
+			if w.Code != tt.want {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+
+			var got Image
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("json.Unmarshal failed: %v", err)
+			}
+			if got.ImageUUID != image.ImageUUID || got.FileName != image.FileName {
+				t.Errorf("got image %s %q, want %s %q", got.ImageUUID, got.FileName, image.ImageUUID, image.FileName)
+			}
+		})
+	}
+}
+
+func TestPostImage(t *testing.T) {
+	r, h := newTestRouter(t)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("file", "photo.png")
+	if err != nil {
+		t.Fatalf("CreateFormFile failed: %v", err)
+	}
+	part.Write([]byte("not really a png"))
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/images", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := serve(r, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusAccepted, w.Body)
+	}
+
+	var resp struct {
+		Metadata Image `json:"metadata"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+
+	image, err := h.images.Get(context.Background(), resp.Metadata.ImageUUID)
+	if err != nil {
+		t.Fatalf("images.Get failed: %v", err)
+	}
+	if image.FileName != "photo.png" || image.Status != StatusUploaded {
+		t.Errorf("got %q with status %s, want photo.png with status %s", image.FileName, image.Status, StatusUploaded)
+	}
+	if _, err := h.store.Head(context.Background(), image.ObjectKey); err != nil {
+		t.Errorf("store.Head(%q) failed: %v", image.ObjectKey, err)
+	}
+
+	w = serve(r, httptest.NewRequest(http.MethodPost, "/api/images", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d without a file, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestDeleteImage(t *testing.T) {
+	r, h := newTestRouter(t)
+	image := insertImage(t, h, "a.png")
+
+	w := serve(r, httptest.NewRequest(http.MethodDelete, "/api/images/"+image.ImageUUID, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	w = serve(r, httptest.NewRequest(http.MethodGet, "/api/images/"+image.ImageUUID, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d after delete, want %d", w.Code, http.StatusNotFound)
+	}
+
+	w = serve(r, httptest.NewRequest(http.MethodDelete, "/api/images/"+image.ImageUUID, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d deleting twice, want %d", w.Code, http.StatusNotFound)
+	}
+
+	w = serve(r, httptest.NewRequest(http.MethodDelete, "/api/images/"+uuid.New().String(), nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d for an unknown image, want %d", w.Code, http.StatusNotFound)
+	}
+}
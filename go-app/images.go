@@ -2,154 +2,186 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"path"
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/prometheus/client_golang/prometheus"
 )
 
 // Image represents the image uploaded by the user.
 type Image struct {
 	// ImageUUID is the unique ID of the image.
-	ImageUUID string
+	ImageUUID string `json:"uuid"`
 
 	// LastModified is the timestamp when the image was last modified.
-	LastModified time.Time
+	LastModified time.Time `json:"lastModified"`
 
 	// FileName is the original file name
-	FileName string
+	FileName string `json:"fileName"`
+
+	// ObjectKey is the key of the object in the object store
+	ObjectKey string `json:"objectKey"`
 
 	// FileSize is the size of the file in bytes
-	FileSize int64
+	FileSize int64 `json:"fileSize"`
+
+	// ContentHash is the hex-encoded SHA-256 of the file content
+	ContentHash string `json:"sha256,omitempty"`
+
+	// ContentType is the MIME type of the file, sniffed from its content
+	ContentType string `json:"contentType"`
+
+	// Width of the decoded image in pixels
+	Width int `json:"width"`
+
+	// Height of the decoded image in pixels
+	Height int `json:"height"`
+
+	// ColorModel of the decoded image, e.g. rgba or ycbcr
+	ColorModel string `json:"colorModel"`
+
+	// TakenAt is the capture time from the EXIF metadata of JPEGs
+	TakenAt *time.Time `json:"takenAt,omitempty"`
+
+	// CameraMake is the camera or device maker from the EXIF metadata
+	CameraMake string `json:"cameraMake,omitempty"`
+
+	// CameraModel is the camera or device model from the EXIF metadata
+	CameraModel string `json:"cameraModel,omitempty"`
 
-	// ContentType is the MIME type of the file
-	ContentType string
+	// Orientation is the EXIF orientation from 1 to 8, 0 if unknown
+	Orientation int `json:"orientation,omitempty"`
+
+	// Latitude is the GPS latitude in decimal degrees from the EXIF metadata
+	Latitude *float64 `json:"latitude,omitempty"`
+
+	// Longitude is the GPS longitude in decimal degrees from the EXIF metadata
+	Longitude *float64 `json:"longitude,omitempty"`
 
 	// ProcessedAt is when the image was processed by our system
-	ProcessedAt time.Time
+	ProcessedAt time.Time `json:"processedAt"`
 
-	// Status indicates processing status (uploaded, processed, error)
-	Status string
+	// Status indicates processing status (uploaded, processing, processed, error)
+	Status string `json:"status"`
+
+	// StatusReason explains an error status
+	StatusReason string `json:"statusReason,omitempty"`
 
 	// Tags for categorization
-	Tags []string
+	Tags []string `json:"tags"`
+
+	// DeletedAt is when the image was deleted; deleted images can be restored until they are purged
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// largeFileSize is the size in bytes above which an image counts as large.
+const largeFileSize = 1024 * 1024
+
+// sizeBucket returns "large" for files over largeFileSize and "small" otherwise.
+func sizeBucket(fileSize int64) string {
+	if fileSize > largeFileSize {
+		return "large"
+	}
+	return "small"
 }
 
-// NewImage creates a new image with enhanced metadata.
+// NewImage creates a new uploaded image; processing detects its content type and dimensions.
 func NewImage(fileName string, fileSize int64, lastModified time.Time) *Image {
 	// Generate a new UUID for the image.
 	id := uuid.New().String()
 
-	// Determine content type based on file extension
-	contentType := "application/octet-stream"
-	if strings.HasSuffix(strings.ToLower(fileName), ".png") {
-		contentType = "image/png"
-	} else if strings.HasSuffix(strings.ToLower(fileName), ".jpg") || strings.HasSuffix(strings.ToLower(fileName), ".jpeg") {
-		contentType = "image/jpeg"
-	} else if strings.HasSuffix(strings.ToLower(fileName), ".gif") {
-		contentType = "image/gif"
-	}
-
 	// Generate tags based on file name and properties
 	tags := []string{"uploaded"}
-	if fileSize > 1024*1024 { // > 1MB
-		tags = append(tags, "large")
-	} else {
-		tags = append(tags, "small")
-	}
+	tags = append(tags, sizeBucket(fileSize))
 
 	// Create an image with enhanced metadata
 	image := &Image{
 		ImageUUID:    id,
 		LastModified: lastModified,
 		FileName:     fileName,
+		ObjectKey:    fileName,
 		FileSize:     fileSize,
-		ContentType:  contentType,
+		ContentType:  "application/octet-stream",
 		ProcessedAt:  time.Now(),
-		Status:       "processed",
+		Status:       StatusUploaded,
 		Tags:         tags,
 	}
 
 	return image
 }
 
-// Save inserts a newly generated image with enhanced metadata into the Postgres database.
-func Save(c *Image, table string, dbpool *pgxpool.Pool, m *metrics, ctx context.Context) error {
-	// Create a new CHILD span to record and trace the request.
-	ctx, span := tracer.Start(ctx, "SQL INSERT")
-	defer span.End()
-
-	// Get the current time to record the duration of the request.
-	now := time.Now()
-
-	// Prepare the database query to insert a record with enhanced metadata.
-	query := fmt.Sprintf(`INSERT INTO %s (
-		image_uuid, last_modified, file_name, file_size, 
-		content_type, processed_at, status, tags
-	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`, table)
-
-	// Convert tags to a comma-separated string for storage
-	tagsStr := ""
-	if len(c.Tags) > 0 {
-		tagsStr = strings.Join(c.Tags, ",")
-	}
-
-	// Execute the query to create a new image record.
-	_, err := dbpool.Exec(context.Background(), query,
-		c.ImageUUID, c.LastModified, c.FileName, c.FileSize,
-		c.ContentType, c.ProcessedAt, c.Status, tagsStr)
-	if err != nil {
-		return fmt.Errorf("dbpool.Exec failed: %w", err)
+// allImages loads every image matching the filter page by page.
+func (h *handler) allImages(ctx context.Context, f ImageFilter) ([]*Image, error) {
+	var images []*Image
+	for f.Offset = 0; ; f.Offset += maxPageSize {
+		f.Limit = maxPageSize
+		page, err := h.images.List(ctx, f)
+		if err != nil {
+			return nil, fmt.Errorf("images.List failed: %w", err)
+		}
+		images = append(images, page...)
+		if len(page) < maxPageSize {
+			return images, nil
+		}
 	}
+}
 
-	// Record the duration of the insert query.
-	m.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+// uploadKey returns the object key for an uploaded image.
+func uploadKey(image *Image) string {
+	// Keep the original extension so the object is easy to recognize in the bucket.
+	ext := strings.ToLower(path.Ext(image.FileName))
 
-	return nil
+	return fmt.Sprintf("uploads/%s%s", image.ImageUUID, ext)
 }
 
-// download downloads S3 image and returns enhanced metadata.
-func download(sess *session.Session, bucket string, key string, m *metrics, ctx context.Context) (*time.Time, int64, context.Context, error) {
-	// Create a new CHILD span to record and trace the request.
-	ctx, span := tracer.Start(ctx, "S3 GET")
-	defer span.End()
+// newObjectImage creates a new uploaded image for an object that is already in
+// the object store. Presigned uploads keep the UUID encoded in their key.
+func newObjectImage(info *ObjectInfo) *Image {
+	image := NewImage(path.Base(info.Key), info.Size, info.LastModified)
+	image.ObjectKey = info.Key
 
-	// Get the current time to record the duration of the request.
-	now := time.Now()
+	if id, ok := uploadKeyUUID(info.Key); ok {
+		image.ImageUUID = id
+	}
+	if info.ContentType != "" {
+		image.ContentType = info.ContentType
+	}
 
-	// Create a new S3 session.
-	svc := s3.New(sess)
+	return image
+}
 
-	// Prepare the request for the S3 bucket.
-	input := &s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
+// uploadKeyUUID returns the image UUID encoded in an upload key, if any.
+func uploadKeyUUID(key string) (string, bool) {
+	name, ok := strings.CutPrefix(key, "uploads/")
+	if !ok || strings.Contains(name, "/") {
+		return "", false
 	}
 
-	// Send the request to the S3 object store to download the image.
-	output, err := svc.GetObject(input)
-	if err != nil {
-		return nil, 0, nil, fmt.Errorf("svc.GetObject failed: %w", err)
+	id := strings.TrimSuffix(name, path.Ext(name))
+	if _, err := uuid.Parse(id); err != nil {
+		return "", false
 	}
 
-	// Read all the image bytes returned by AWS.
-	data, err := io.ReadAll(output.Body)
+	return id, true
+}
+
+// hashObject streams the object through SHA-256 without holding it in memory.
+func hashObject(ctx context.Context, store ObjectStore, key string) (*ObjectInfo, string, error) {
+	obj, err := store.Get(ctx, key)
 	if err != nil {
-		return nil, 0, nil, fmt.Errorf("io.ReadAll failed: %w", err)
+		return nil, "", fmt.Errorf("store.Get failed: %w", err)
 	}
+	defer obj.Body.Close()
 
-	// Get file size
-	fileSize := int64(len(data))
-
-	// Record the duration of the request to S3.
-	m.duration.With(prometheus.Labels{"op": "s3"}).Observe(time.Since(now).Seconds())
+	h := sha256.New()
+	if _, err := io.Copy(h, obj.Body); err != nil {
+		return nil, "", fmt.Errorf("io.Copy failed: %w", err)
+	}
 
-	return output.LastModified, fileSize, ctx, nil
+	return &obj.ObjectInfo, hex.EncodeToString(h.Sum(nil)), nil
 }
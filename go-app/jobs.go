@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrNoJob is returned by Claim when no job is ready to run.
+var ErrNoJob = errors.New("no job ready")
+
+// Job asks a worker to process an image.
+type Job struct {
+	// ID is the unique ID of the job.
+	ID int64
+
+	// ImageUUID is the image to process.
+	ImageUUID string
+
+	// Attempts is the number of times the job was claimed, including the current one.
+	Attempts int
+
+	// CreatedAt is when the job was enqueued.
+	CreatedAt time.Time
+}
+
+// JobQueue is a durable queue of image processing jobs.
+type JobQueue interface {
+	// Enqueue adds a job for the image.
+	Enqueue(ctx context.Context, imageID string) error
+
+	// Claim takes the oldest ready job, or returns ErrNoJob.
+	Claim(ctx context.Context) (*Job, error)
+
+	// Complete removes a finished job.
+	Complete(ctx context.Context, job *Job) error
+
+	// Retry puts the job back into the queue to run again after the delay.
+	Retry(ctx context.Context, job *Job, reason string, delay time.Duration) error
+
+	// Fail gives up on the job and keeps it for inspection.
+	Fail(ctx context.Context, job *Job, reason string) error
+
+	// Depth returns the number of jobs waiting to run.
+	Depth(ctx context.Context) (int64, error)
+}
+
+// pgJobQueue keeps jobs in Postgres so they survive restarts.
+type pgJobQueue struct {
+	// Postgres connection pool
+	dbpool *pgxpool.Pool
+
+	// Running jobs not updated within this timeout belong to a crashed worker
+	// and may be claimed again.
+	staleAfter time.Duration
+
+	// Prometheus metrics
+	metrics *metrics
+}
+
+// newPgJobQueue returns a queue backed by the go_image_job table.
+func newPgJobQueue(dbpool *pgxpool.Pool, staleAfter time.Duration, m *metrics) *pgJobQueue {
+	return &pgJobQueue{dbpool: dbpool, staleAfter: staleAfter, metrics: m}
+}
+
+// Enqueue inserts a queued job for the image.
+func (q *pgJobQueue) Enqueue(ctx context.Context, imageID string) error {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL INSERT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	_, err := q.dbpool.Exec(ctx, `INSERT INTO go_image_job (image_uuid) VALUES ($1)`, imageID)
+	if err != nil {
+		return fmt.Errorf("dbpool.Exec failed: %w", err)
+	}
+
+	// Record the duration of the insert query.
+	q.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return nil
+}
+
+// Claim locks the oldest ready job with SKIP LOCKED so concurrent workers,
+// even in other replicas, never take the same job.
+func (q *pgJobQueue) Claim(ctx context.Context) (*Job, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL UPDATE")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := `UPDATE go_image_job SET state = 'running', attempts = attempts + 1, updated_at = now()
+		WHERE id = (
+			SELECT id FROM go_image_job
+			WHERE (state = 'queued' AND run_after <= now())
+			   OR (state = 'running' AND updated_at < now() - $1::interval)
+			ORDER BY id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING id, image_uuid, attempts, created_at`
+
+	var job Job
+	err := q.dbpool.QueryRow(ctx, query, q.staleAfter).Scan(&job.ID, &job.ImageUUID, &job.Attempts, &job.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNoJob
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dbpool.QueryRow failed: %w", err)
+	}
+
+	// Record the duration of the claim query.
+	q.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return &job, nil
+}
+
+// Complete deletes the finished job.
+func (q *pgJobQueue) Complete(ctx context.Context, job *Job) error {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL DELETE")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	_, err := q.dbpool.Exec(ctx, `DELETE FROM go_image_job WHERE id = $1`, job.ID)
+	if err != nil {
+		return fmt.Errorf("dbpool.Exec failed: %w", err)
+	}
+
+	// Record the duration of the delete query.
+	q.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return nil
+}
+
+// Retry requeues the job after the delay.
+func (q *pgJobQueue) Retry(ctx context.Context, job *Job, reason string, delay time.Duration) error {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL UPDATE")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	_, err := q.dbpool.Exec(ctx, `UPDATE go_image_job
+		SET state = 'queued', last_error = $2, run_after = now() + $3::interval, updated_at = now()
+		WHERE id = $1`, job.ID, reason, delay)
+	if err != nil {
+		return fmt.Errorf("dbpool.Exec failed: %w", err)
+	}
+
+	// Record the duration of the update query.
+	q.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return nil
+}
+
+// Fail marks the job as failed.
+func (q *pgJobQueue) Fail(ctx context.Context, job *Job, reason string) error {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL UPDATE")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	_, err := q.dbpool.Exec(ctx, `UPDATE go_image_job SET state = 'failed', last_error = $2, updated_at = now()
+		WHERE id = $1`, job.ID, reason)
+	if err != nil {
+		return fmt.Errorf("dbpool.Exec failed: %w", err)
+	}
+
+	// Record the duration of the update query.
+	q.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return nil
+}
+
+// Depth counts the queued jobs.
+func (q *pgJobQueue) Depth(ctx context.Context) (int64, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL SELECT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	var depth int64
+	err := q.dbpool.QueryRow(ctx, `SELECT COUNT(*) FROM go_image_job WHERE state = 'queued'`).Scan(&depth)
+	if err != nil {
+		return 0, fmt.Errorf("dbpool.QueryRow failed: %w", err)
+	}
+
+	// Record the duration of the select query.
+	q.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return depth, nil
+}
+
+// memoryJob is a job held by memoryJobQueue.
+type memoryJob struct {
+	Job
+	runAfter time.Time
+}
+
+// memoryJobQueue keeps jobs in memory; they are lost on restart.
+type memoryJobQueue struct {
+	mu     sync.Mutex
+	nextID int64
+	queued []*memoryJob
+}
+
+// newMemoryJobQueue returns an empty in-memory queue.
+func newMemoryJobQueue() *memoryJobQueue {
+	return &memoryJobQueue{}
+}
+
+// Enqueue appends a job for the image.
+func (q *memoryJobQueue) Enqueue(ctx context.Context, imageID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.nextID++
+	q.queued = append(q.queued, &memoryJob{
+		Job:      Job{ID: q.nextID, ImageUUID: imageID, CreatedAt: time.Now()},
+		runAfter: time.Now(),
+	})
+	return nil
+}
+
+// Claim removes the oldest ready job from the queue.
+func (q *memoryJobQueue) Claim(ctx context.Context) (*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	for i, j := range q.queued {
+		if j.runAfter.After(now) {
+			continue
+		}
+		q.queued = append(q.queued[:i], q.queued[i+1:]...)
+		j.Attempts++
+		job := j.Job
+		return &job, nil
+	}
+
+	return nil, ErrNoJob
+}
+
+// Complete forgets the finished job.
+func (q *memoryJobQueue) Complete(ctx context.Context, job *Job) error {
+	return nil
+}
+
+// Retry puts the job back at the end of the queue.
+func (q *memoryJobQueue) Retry(ctx context.Context, job *Job, reason string, delay time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.queued = append(q.queued, &memoryJob{Job: *job, runAfter: time.Now().Add(delay)})
+	return nil
+}
+
+// Fail forgets the failed job.
+func (q *memoryJobQueue) Fail(ctx context.Context, job *Job, reason string) error {
+	return nil
+}
+
+// Depth counts the queued jobs.
+func (q *memoryJobQueue) Depth(ctx context.Context) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return int64(len(q.queued)), nil
+}
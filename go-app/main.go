@@ -5,11 +5,9 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/prometheus/client_golang/prometheus"
@@ -56,17 +54,44 @@ func newTraceProvider(exp sdktrace.SpanExporter) *sdktrace.TracerProvider {
 	return sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(r))
 }
 
-// handler to connect to S3 and Database
+// handler to connect to the object store and Database
 type handler struct {
 	// Prometheus metrics
 	metrics *metrics
 
-	// S3 seesion, should be shared
-	sess *session.Session
+	// Object store to keep image bytes (S3, local directory or memory)
+	store ObjectStore
 
 	// Postgres connection pool
 	dbpool *pgxpool.Pool
 
+	// Repository to store image metadata
+	images ImageRepository
+
+	// Repository to store image derivatives such as thumbnails
+	derivatives DerivativeRepository
+
+	// Queue of image processing jobs
+	jobs JobQueue
+
+	// Last bucket notification applied to every object key
+	bucketEvents BucketEventLog
+
+	// Append-only log of image reads and changes
+	auditLog AuditLog
+
+	// Registry of the known devices
+	devices DeviceRepository
+
+	// Firmware releases and their rollout campaigns
+	firmware FirmwareRepository
+
+	// Time series of the device readings
+	telemetry TelemetryStore
+
+	// Latest device readings exported to Prometheus
+	telemetryGauges *telemetryGauges
+
 	// App configuration object
 	config *Config
 }
@@ -76,6 +101,29 @@ func main() {
 	var c Config
 	c.loadConfig("config.yaml")
 
+	// Fail fast on a broken derivative config instead of on the first upload.
+	if err := validateDerivatives(c.Derivatives); err != nil {
+		log.Fatalf("invalid derivatives config: %s", err)
+	}
+
+	// Run a one-off maintenance command and exit, e.g. "go-monitoring migrate up".
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			runMigrate(&c, os.Args[2:])
+			return
+		case "dedupe":
+			runDedupe(&c, os.Args[2:])
+			return
+		case "reconcile":
+			runReconcile(&c, os.Args[2:])
+			return
+		case "sweep":
+			runSweep(&c, os.Args[2:])
+			return
+		}
+	}
+
 	// Initializes a new Go Context.
 	ctx := context.Background()
 	// Create console exporter to print traces to the console.
@@ -112,16 +160,90 @@ func main() {
 	}()
 
 	// Initialize Gin handler.
-	h := handler{config: &c, metrics: m}
-	h.s3Connect()
-	h.dbConnect()
+	gaugeExpiry := time.Duration(c.Telemetry.GaugeExpirySeconds) * time.Second
+	h := handler{config: &c, metrics: m, telemetryGauges: newTelemetryGauges(m.telemetry, c.Telemetry.MaxGaugeDevices, gaugeExpiry)}
+	h.storeConnect()
+	h.repoConnect()
+
+	// Import the fixture devices the first time the registry is used.
+	seeded, err := h.devices.Seed(ctx, fixtureDevices())
+	if err != nil {
+		log.Fatalf("Unable to seed devices: %s", err)
+	}
+	if seeded {
+		log.Printf("Seeded the device registry with %d devices", len(fixtureDevices()))
+	}
+
+	// Process uploaded images in the background.
+	h.startWorkers(ctx)
+
+	// Keep the database in sync with objects added or removed behind our back.
+	if c.Reconcile.IntervalSeconds > 0 {
+		go h.reconcileLoop(ctx)
+	}
+
+	// Expire images according to the retention rules.
+	if c.Retention.IntervalSeconds > 0 {
+		go h.sweepLoop(ctx)
+	}
+
+	// Purge deleted images once they can no longer be restored.
+	if c.Retention.PurgeIntervalSeconds > 0 {
+		go h.purgeLoop(ctx)
+	}
+
+	// Mark devices that stopped sending heartbeats as offline.
+	if c.Devices.CheckIntervalSeconds > 0 {
+		go h.offlineLoop(ctx)
+	}
+
+	// Export the number of devices by status and firmware.
+	go h.deviceGaugesLoop(ctx)
 
 	r := gin.Default()
 
 	// Define handler functions for each endpoint.
 	r.GET("/api/devices", h.getDevices)
-	r.GET("/api/images", h.getImage)
+	r.GET("/api/devices/firmware-report", h.getFirmwareReport)
+	r.GET("/api/devices/:uuid", h.getDevice)
+	r.POST("/api/devices/:uuid", h.createDevice)
+	r.PUT("/api/devices/:uuid", h.updateDevice)
+	r.DELETE("/api/devices/:uuid", h.deleteDevice)
+	r.POST("/api/devices/:uuid/heartbeat", h.postHeartbeat)
+	r.GET("/api/devices/:uuid/firmware", h.getDeviceFirmware)
+	r.POST("/api/devices/:uuid/firmware", h.postDeviceFirmware)
+	r.GET("/api/devices/:uuid/telemetry", h.getTelemetry)
+	r.POST("/api/telemetry", h.postTelemetry)
+	r.GET("/api/firmware", h.listFirmware)
+	r.POST("/api/firmware", h.postFirmware)
+	r.GET("/api/firmware/campaigns", h.listCampaigns)
+	r.POST("/api/firmware/campaigns", h.createCampaign)
+	r.GET("/api/firmware/campaigns/:id", h.getCampaign)
+	r.POST("/api/firmware/campaigns/:id/pause", h.pauseCampaign)
+	r.POST("/api/firmware/campaigns/:id/resume", h.resumeCampaign)
+	r.POST("/api/firmware/campaigns/:id/abort", h.abortCampaign)
+	r.GET("/api/firmware/:version", h.getFirmware)
+	r.GET("/api/firmware/:version/artifact", h.getFirmwareArtifact)
+	r.GET("/api/images", h.listImages)
+	r.POST("/api/images", h.postImage)
+	r.POST("/api/images/ingest", h.ingestImage)
+	r.POST("/api/images/presign", h.presignUpload)
+	r.GET("/api/images/search", h.searchImages)
+	r.GET("/api/images/:uuid", h.getImage)
+	r.DELETE("/api/images/:uuid", h.deleteImage)
+	r.GET("/api/images/:uuid/thumbnail", h.getImageThumbnail)
+	r.GET("/api/images/:uuid/url", h.presignDownload)
+	r.POST("/api/images/:uuid/finalize", h.finalizeUpload)
+	r.GET("/api/images/:uuid/history", h.getImageHistory)
+	r.POST("/api/images/:uuid/reprocess", h.reprocessImage)
+	r.POST("/api/images/:uuid/restore", h.restoreImage)
+	r.POST("/api/images/:uuid/tags", h.addImageTags)
+	r.DELETE("/api/images/:uuid/tags/:tag", h.removeImageTag)
+	r.GET("/api/tags", h.listTags)
 	r.GET("/api/stats", h.getStats)
+	r.GET("/api/audit", h.listAudit)
+	r.GET("/api/audit/export", h.exportAudit)
+	r.POST("/api/events/s3", h.postBucketEvents)
 	r.GET("/health", h.getHealth)
 
 	// Start the main Gin HTTP server.
@@ -129,61 +251,6 @@ func main() {
 	r.Run(fmt.Sprintf(":%d", c.AppPort))
 }
 
-// getDevices responds with the list of all connected devices as JSON.
-func (h *handler) getDevices(c *gin.Context) {
-	// Record metrics for this operation
-	start := time.Now()
-	defer func() {
-		h.metrics.duration.With(prometheus.Labels{"op": "devices"}).Observe(time.Since(start).Seconds())
-	}()
-
-	c.JSON(http.StatusOK, devices())
-}
-
-// getImage downloads image from S3
-func (h *handler) getImage(c *gin.Context) {
-	// Create a new ROOT span to record and trace the request.
-	ctx, span := tracer.Start(c, "HTTP GET /api/images")
-	defer span.End()
-
-	// Use thumbnail.png file
-	fileName := "thumbnail.png"
-
-	// Download the image from S3.
-	lastModified, fileSize, ctx, err := download(h.sess, h.config.S3Config.Bucket, fileName, h.metrics, ctx)
-	if err != nil {
-		log.Printf("download failed: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal error"})
-		return
-	}
-
-	// Generate a new image with enhanced metadata.
-	image := NewImage(fileName, fileSize, *lastModified)
-
-	// Save the image metadata to the database.
-	err = Save(image, "go_image", h.dbpool, h.metrics, ctx)
-	if err != nil {
-		log.Printf("save failed: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
-		return
-	}
-
-	// Return enhanced metadata in response
-	c.JSON(http.StatusOK, gin.H{
-		"message": "saved",
-		"metadata": gin.H{
-			"uuid":         image.ImageUUID,
-			"fileName":     image.FileName,
-			"fileSize":     image.FileSize,
-			"contentType":  image.ContentType,
-			"status":       image.Status,
-			"tags":         image.Tags,
-			"processedAt":  image.ProcessedAt,
-			"lastModified": image.LastModified,
-		},
-	})
-}
-
 // getHealth responds with a HTTP 200 or 5xx on error.
 func (h *handler) getHealth(c *gin.Context) {
 	// Record metrics for health check
@@ -203,45 +270,34 @@ func (h *handler) getStats(c *gin.Context) {
 		h.metrics.duration.With(prometheus.Labels{"op": "stats"}).Observe(time.Since(start).Seconds())
 	}()
 
+	ctx := c.Request.Context()
+
 	// Get image count from database
-	var imageCount int64
-	err := h.dbpool.QueryRow(context.Background(), "SELECT COUNT(*) FROM go_image").Scan(&imageCount)
+	imageCount, err := h.images.Count(ctx, ImageFilter{})
 	if err != nil {
 		log.Printf("failed to get image count: %v", err)
 		imageCount = -1
 	}
 
+	// Get the number of deleted images waiting to be purged
+	deletedCount, err := h.images.Count(ctx, ImageFilter{Deleted: true})
+	if err != nil {
+		log.Printf("failed to get deleted image count: %v", err)
+		deletedCount = -1
+	}
+
 	// Get latest images
-	var latestImages []map[string]interface{}
-	rows, err := h.dbpool.Query(context.Background(),
-		"SELECT image_uuid, file_name, file_size, content_type, status, processed_at FROM go_image ORDER BY processed_at DESC LIMIT 5")
+	latestImages, err := h.images.List(ctx, ImageFilter{Limit: 5})
 	if err != nil {
 		log.Printf("failed to get latest images: %v", err)
-	} else {
-		defer rows.Close()
-		for rows.Next() {
-			var uuid, fileName, contentType, status string
-			var fileSize int64
-			var processedAt time.Time
-
-			if err := rows.Scan(&uuid, &fileName, &fileSize, &contentType, &status, &processedAt); err == nil {
-				latestImages = append(latestImages, map[string]interface{}{
-					"uuid":        uuid,
-					"fileName":    fileName,
-					"fileSize":    fileSize,
-					"contentType": contentType,
-					"status":      status,
-					"processedAt": processedAt,
-				})
-			}
-		}
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"timestamp": time.Now(),
 		"database": gin.H{
-			"totalImages":  imageCount,
-			"latestImages": latestImages,
+			"totalImages":   imageCount,
+			"deletedImages": deletedCount,
+			"latestImages":  latestImages,
 		},
 		"system": gin.H{
 			"uptime":  time.Since(start), // Простой uptime с момента запроса
@@ -249,33 +305,73 @@ func (h *handler) getStats(c *gin.Context) {
 			"service": "go-monitoring",
 		},
 		"endpoints": gin.H{
-			"health":  "/health",
-			"devices": "/api/devices",
-			"images":  "/api/images",
-			"stats":   "/api/stats",
-			"metrics": ":8081/metrics",
+			"health":   "/health",
+			"devices":  "/api/devices",
+			"firmware": "/api/firmware",
+			"images":   "/api/images",
+			"tags":     "/api/tags",
+			"stats":    "/api/stats",
+			"metrics":  ":8081/metrics",
 		},
 	})
 }
 
-// s3Connect initializes the S3 session.
-func (h *handler) s3Connect() {
-	// Get credentials to authorize with AWS S3 API.
-	crds := credentials.NewStaticCredentials(h.config.S3Config.User, h.config.S3Config.Secret, "")
-
-	// Create S3 config.
-	s3c := aws.Config{
-		Region:           &h.config.S3Config.Region,
-		Endpoint:         &h.config.S3Config.Endpoint,
-		S3ForcePathStyle: &h.config.S3Config.PathStyle,
-		Credentials:      crds,
+// newCommandHandler returns a handler connected to the object store and
+// repositories for the maintenance subcommands, which serve no requests.
+func newCommandHandler(c *Config) *handler {
+	// Repositories trace their queries; the global provider is a no-op here.
+	tracer = otel.Tracer("go-app")
+
+	h := &handler{config: c, metrics: NewMetrics(prometheus.NewRegistry())}
+	h.storeConnect()
+	h.repoConnect()
+
+	return h
+}
+
+// storeConnect initializes the object store selected in the config.
+func (h *handler) storeConnect() {
+	store, err := newObjectStore(h.config, h.metrics)
+	if err != nil {
+		log.Fatalf("Unable to create object store: %s", err)
 	}
 
-	// Establish a new session with the AWS S3 API.
-	h.sess = session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-		Config:            s3c,
-	}))
+	h.store = store
+}
+
+// repoConnect initializes the image repository selected in the config.
+func (h *handler) repoConnect() {
+	switch h.config.DbConfig.Backend {
+	case "", "postgres":
+		h.dbConnect()
+
+		// Create or upgrade the schema before serving any request.
+		if h.config.DbConfig.AutoMigrate {
+			if err := migrateUp(context.Background(), h.dbpool); err != nil {
+				log.Fatalf("Unable to migrate database: %s", err)
+			}
+		}
+
+		h.images = newPgImageRepository(h.dbpool, "go_image", h.metrics)
+		h.derivatives = newPgDerivativeRepository(h.dbpool, h.metrics)
+		h.jobs = newPgJobQueue(h.dbpool, time.Duration(h.config.Workers.StaleAfterSeconds)*time.Second, h.metrics)
+		h.bucketEvents = newPgBucketEventLog(h.dbpool, h.metrics)
+		h.auditLog = newPgAuditLog(h.dbpool, h.metrics)
+		h.devices = newPgDeviceRepository(h.dbpool, h.metrics)
+		h.firmware = newPgFirmwareRepository(h.dbpool, h.metrics)
+		h.telemetry = newPgTelemetryStore(h.dbpool, h.metrics)
+	case "memory":
+		h.images = newMemoryImageRepository()
+		h.derivatives = newMemoryDerivativeRepository()
+		h.jobs = newMemoryJobQueue()
+		h.bucketEvents = newMemoryBucketEventLog()
+		h.auditLog = newMemoryAuditLog()
+		h.devices = newMemoryDeviceRepository()
+		h.firmware = newMemoryFirmwareRepository()
+		h.telemetry = newMemoryTelemetryStore()
+	default:
+		log.Fatalf("Unknown db backend %q", h.config.DbConfig.Backend)
+	}
 }
 
 // dbConnect creates a connection pool to connect to Postgres.
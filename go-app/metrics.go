@@ -7,6 +7,39 @@ type metrics struct {
 	// A metric to record the duration of requests,
 	// such as database queries or requests to the S3 object store.
 	duration *prometheus.SummaryVec
+
+	// Number of image processing jobs waiting in the queue.
+	queueDepth prometheus.Gauge
+
+	// Time from enqueuing an image processing job until it finishes.
+	jobLatency *prometheus.HistogramVec
+
+	// Images imported, marked missing or restored by the bucket reconciler.
+	reconciled *prometheus.CounterVec
+
+	// Number of objects found in the bucket by the last reconciliation.
+	bucketObjects prometheus.Gauge
+
+	// Number of images whose object was missing at the last reconciliation.
+	missingImages prometheus.Gauge
+
+	// Images deleted by the retention sweeper, by rule.
+	retentionDeleted *prometheus.CounterVec
+
+	// Bytes of objects deleted by the retention sweeper, by rule.
+	retentionBytes *prometheus.CounterVec
+
+	// Number of registered devices, by status and firmware.
+	devices *prometheus.GaugeVec
+
+	// Firmware rollout results reported by devices, by state.
+	firmwareRollouts *prometheus.CounterVec
+
+	// Latest telemetry value of a device, by device and metric.
+	telemetry *prometheus.GaugeVec
+
+	// Telemetry samples received, by metric.
+	telemetrySamples *prometheus.CounterVec
 }
 
 // Create new metrics and register them with the Prometheus registry.
@@ -19,9 +52,67 @@ func NewMetrics(reg prometheus.Registerer) *metrics {
 			Help:       "Duration of the request.",
 			Objectives: map[float64]float64{0.9: 0.01, 0.99: 0.001},
 		}, []string{"op"}),
+		queueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "myapp",
+			Name:      "job_queue_depth",
+			Help:      "Number of image processing jobs waiting in the queue.",
+		}),
+		jobLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "myapp",
+			Name:      "job_latency_seconds",
+			Help:      "Time from enqueuing an image processing job until it finishes.",
+			Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+		}, []string{"result"}),
+		reconciled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "myapp",
+			Name:      "reconcile_images_total",
+			Help:      "Images imported, marked missing or restored by the bucket reconciler.",
+		}, []string{"result"}),
+		bucketObjects: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "myapp",
+			Name:      "reconcile_bucket_objects",
+			Help:      "Number of objects found in the bucket by the last reconciliation.",
+		}),
+		missingImages: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "myapp",
+			Name:      "reconcile_missing_images",
+			Help:      "Number of images whose object was missing at the last reconciliation.",
+		}),
+		retentionDeleted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "myapp",
+			Name:      "retention_deleted_images_total",
+			Help:      "Images deleted by the retention sweeper.",
+		}, []string{"rule"}),
+		retentionBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "myapp",
+			Name:      "retention_reclaimed_bytes_total",
+			Help:      "Bytes of images and derivatives deleted by the retention sweeper.",
+		}, []string{"rule"}),
+		devices: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "myapp",
+			Name:      "devices",
+			Help:      "Number of registered devices by status (online or offline) and firmware.",
+		}, []string{"status", "firmware"}),
+		firmwareRollouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "myapp",
+			Name:      "firmware_rollouts_total",
+			Help:      "Firmware rollout results reported by devices (succeeded or failed).",
+		}, []string{"state"}),
+		telemetry: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "myapp",
+			Name:      "device_telemetry",
+			Help:      "Latest telemetry value reported by a device, by device and metric.",
+		}, []string{"device", "metric"}),
+		telemetrySamples: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "myapp",
+			Name:      "telemetry_samples_total",
+			Help:      "Telemetry samples received, by metric.",
+		}, []string{"metric"}),
 	}
 	// Register metrics with Prometheus registry.
-	reg.MustRegister(m.duration)
+	reg.MustRegister(m.duration, m.queueDepth, m.jobLatency, m.reconciled, m.bucketObjects, m.missingImages,
+		m.retentionDeleted, m.retentionBytes, m.devices, m.firmwareRollouts,
+		m.telemetry, m.telemetrySamples)
 
 	return m
 }
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Migrations are embedded so the binary can create its own schema.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the Postgres advisory lock key that serializes migrations
+// when several replicas start at the same time.
+const migrationLockID int64 = 0x676f5f696d616765
+
+// migration is a single versioned schema change.
+type migration struct {
+	// Version orders the migrations; it comes from the file name prefix.
+	Version int
+
+	// Name is the descriptive part of the file name.
+	Name string
+
+	// Up applies the change.
+	Up string
+
+	// Down reverts the change.
+	Down string
+}
+
+// loadMigrations reads NNNN_name.up.sql and NNNN_name.down.sql pairs, ordered by version.
+func loadMigrations(fsys fs.FS) ([]migration, error) {
+	files, err := fs.Glob(fsys, "migrations/*.sql")
+	if err != nil {
+		return nil, fmt.Errorf("fs.Glob failed: %w", err)
+	}
+
+	byVersion := make(map[int]*migration)
+	for _, file := range files {
+		base := strings.TrimPrefix(file, "migrations/")
+
+		// Split "0001_create_go_image.up.sql" into version, name and direction.
+		stem, direction, ok := strings.Cut(strings.TrimSuffix(base, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("invalid migration file name %q", base)
+		}
+		prefix, name, ok := strings.Cut(stem, "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name %q", base)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %q", base)
+		}
+
+		sql, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, fmt.Errorf("fs.ReadFile failed: %w", err)
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(sql)
+		} else {
+			m.Down = string(sql)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up script", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// withMigrationLock runs fn on a dedicated connection while holding the migration advisory lock.
+func withMigrationLock(ctx context.Context, dbpool *pgxpool.Pool, fn func(conn *pgxpool.Conn) error) error {
+	// Advisory locks belong to a session, so lock and unlock on the same connection.
+	conn, err := dbpool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("dbpool.Acquire failed: %w", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return fmt.Errorf("pg_advisory_lock failed: %w", err)
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID)
+
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_version (
+		version    INT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return fmt.Errorf("create schema_version failed: %w", err)
+	}
+
+	return fn(conn)
+}
+
+// appliedVersions returns the versions recorded in schema_version.
+func appliedVersions(ctx context.Context, conn *pgxpool.Conn) (map[int]time.Time, error) {
+	rows, err := conn.Query(ctx, "SELECT version, applied_at FROM schema_version")
+	if err != nil {
+		return nil, fmt.Errorf("conn.Query failed: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("rows.Scan failed: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+// migrateUp applies all pending migrations, each in its own transaction.
+func migrateUp(ctx context.Context, dbpool *pgxpool.Pool) error {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return err
+	}
+
+	return withMigrationLock(ctx, dbpool, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+
+			err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, m.Up); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, "INSERT INTO schema_version (version, name) VALUES ($1, $2)", m.Version, m.Name)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+			}
+
+			log.Printf("Applied migration %04d_%s", m.Version, m.Name)
+		}
+
+		return nil
+	})
+}
+
+// migrateDown reverts the given number of most recently applied migrations.
+func migrateDown(ctx context.Context, dbpool *pgxpool.Pool, steps int) error {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return err
+	}
+
+	return withMigrationLock(ctx, dbpool, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		// Walk the migrations newest first.
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migration %04d_%s has no down script", m.Version, m.Name)
+			}
+
+			err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, m.Down); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, "DELETE FROM schema_version WHERE version = $1", m.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+			}
+
+			log.Printf("Reverted migration %04d_%s", m.Version, m.Name)
+			steps--
+		}
+
+		return nil
+	})
+}
+
+// migrateStatus prints every known migration and when it was applied.
+func migrateStatus(ctx context.Context, dbpool *pgxpool.Pool) error {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return err
+	}
+
+	return withMigrationLock(ctx, dbpool, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			state := "pending"
+			if t, ok := applied[m.Version]; ok {
+				state = "applied " + t.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%-40s %s\n", m.Version, m.Name, state)
+		}
+
+		return nil
+	})
+}
+
+// runMigrate handles the "migrate [up | down [n] | status]" subcommand.
+func runMigrate(c *Config, args []string) {
+	h := handler{config: c}
+	h.dbConnect()
+	defer h.dbpool.Close()
+
+	ctx := context.Background()
+
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	var err error
+	switch cmd {
+	case "up":
+		err = migrateUp(ctx, h.dbpool)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				log.Fatalf("invalid number of steps %q", args[1])
+			}
+		}
+		err = migrateDown(ctx, h.dbpool, steps)
+	case "status":
+		err = migrateStatus(ctx, h.dbpool)
+	default:
+		log.Fatalf("usage: go-monitoring migrate [up | down [n] | status]")
+	}
+	if err != nil {
+		log.Fatalf("migrate %s failed: %v", cmd, err)
+	}
+}
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS go_image (
+    image_uuid    UUID PRIMARY KEY,
+    last_modified TIMESTAMPTZ NOT NULL,
+    file_name     TEXT NOT NULL,
+    object_key    TEXT NOT NULL,
+    file_size     BIGINT NOT NULL,
+    content_type  TEXT NOT NULL,
+    processed_at  TIMESTAMPTZ NOT NULL,
+    status        TEXT NOT NULL,
+    tags          TEXT NOT NULL DEFAULT ''
+);
+
+-- Tables created by hand before migrations existed have no object_key;
+-- those images were always stored under their file name.
+ALTER TABLE go_image ADD COLUMN IF NOT EXISTS object_key TEXT;
+UPDATE go_image SET object_key = file_name WHERE object_key IS NULL;
+ALTER TABLE go_image ALTER COLUMN object_key SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS go_image_processed_at_idx ON go_image (processed_at DESC);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS go_image_tags_idx;
+
+ALTER TABLE go_image ALTER COLUMN tags DROP DEFAULT;
+ALTER TABLE go_image ALTER COLUMN tags TYPE TEXT USING array_to_string(tags, ',');
+ALTER TABLE go_image ALTER COLUMN tags SET DEFAULT '';
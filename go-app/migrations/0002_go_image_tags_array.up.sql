@@ -0,0 +1,9 @@
+-- Tags used to be a comma-joined string, which broke tags containing commas
+-- and could not be queried; store them as a text array instead.
+ALTER TABLE go_image ALTER COLUMN tags DROP DEFAULT;
+ALTER TABLE go_image ALTER COLUMN tags TYPE TEXT[]
+    USING COALESCE(string_to_array(NULLIF(tags, ''), ','), '{}');
+ALTER TABLE go_image ALTER COLUMN tags SET DEFAULT '{}';
+ALTER TABLE go_image ALTER COLUMN tags SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS go_image_tags_idx ON go_image USING GIN (tags);
@@ -0,0 +1,4 @@
+ALTER TABLE go_image DROP COLUMN IF EXISTS status_reason;
+ALTER TABLE go_image DROP COLUMN IF EXISTS color_model;
+ALTER TABLE go_image DROP COLUMN IF EXISTS height;
+ALTER TABLE go_image DROP COLUMN IF EXISTS width;
@@ -0,0 +1,4 @@
+ALTER TABLE go_image ADD COLUMN IF NOT EXISTS width INT NOT NULL DEFAULT 0;
+ALTER TABLE go_image ADD COLUMN IF NOT EXISTS height INT NOT NULL DEFAULT 0;
+ALTER TABLE go_image ADD COLUMN IF NOT EXISTS color_model TEXT NOT NULL DEFAULT '';
+ALTER TABLE go_image ADD COLUMN IF NOT EXISTS status_reason TEXT NOT NULL DEFAULT '';
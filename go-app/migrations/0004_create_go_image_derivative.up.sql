@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS go_image_derivative (
+    image_uuid   UUID NOT NULL REFERENCES go_image (image_uuid) ON DELETE CASCADE,
+    size         INT NOT NULL,
+    mode         TEXT NOT NULL,
+    object_key   TEXT NOT NULL,
+    width        INT NOT NULL,
+    height       INT NOT NULL,
+    file_size    BIGINT NOT NULL,
+    content_type TEXT NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (image_uuid, size, mode)
+);
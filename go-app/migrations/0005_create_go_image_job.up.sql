@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS go_image_job (
+    id          BIGSERIAL PRIMARY KEY,
+    image_uuid  UUID NOT NULL REFERENCES go_image (image_uuid) ON DELETE CASCADE,
+    state       TEXT NOT NULL DEFAULT 'queued',
+    attempts    INT NOT NULL DEFAULT 0,
+    last_error  TEXT NOT NULL DEFAULT '',
+    run_after   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Workers only ever look for queued or running jobs.
+CREATE INDEX IF NOT EXISTS go_image_job_pending_idx ON go_image_job (id)
+    WHERE state IN ('queued', 'running');
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS image_events (
+    id          BIGSERIAL PRIMARY KEY,
+    image_uuid  UUID NOT NULL REFERENCES go_image (image_uuid) ON DELETE CASCADE,
+    from_status TEXT NOT NULL,
+    to_status   TEXT NOT NULL,
+    reason      TEXT NOT NULL DEFAULT '',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS image_events_image_uuid_idx ON image_events (image_uuid, id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS go_image_content_sha256_idx;
+
+ALTER TABLE go_image DROP COLUMN IF EXISTS content_sha256;
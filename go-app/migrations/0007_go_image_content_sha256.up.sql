@@ -0,0 +1,4 @@
+-- Rows ingested before hashing keep a NULL hash until "go-monitoring dedupe" fills it in.
+ALTER TABLE go_image ADD COLUMN IF NOT EXISTS content_sha256 TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS go_image_content_sha256_idx ON go_image (content_sha256);
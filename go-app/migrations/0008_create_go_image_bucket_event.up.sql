@@ -0,0 +1,9 @@
+-- The last bucket notification applied to every object key, so that retried
+-- and out-of-order webhook deliveries are ignored.
+CREATE TABLE IF NOT EXISTS go_image_bucket_event (
+    object_key TEXT PRIMARY KEY,
+    event_name TEXT NOT NULL,
+    sequencer  TEXT NOT NULL DEFAULT '',
+    etag       TEXT NOT NULL DEFAULT '',
+    applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
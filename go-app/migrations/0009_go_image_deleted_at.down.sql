@@ -0,0 +1,9 @@
+-- Tombstoned rows would break the full unique index; drop them first.
+DELETE FROM go_image WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS go_image_content_sha256_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS go_image_content_sha256_idx ON go_image (content_sha256);
+
+DROP INDEX IF EXISTS go_image_deleted_at_idx;
+
+ALTER TABLE go_image DROP COLUMN IF EXISTS deleted_at;
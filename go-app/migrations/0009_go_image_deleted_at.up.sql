@@ -0,0 +1,8 @@
+-- Deleted images keep their row as a tombstone until the sweeper purges them.
+ALTER TABLE go_image ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS go_image_deleted_at_idx ON go_image (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Only live images must have unique content, so a deleted image can be uploaded again.
+DROP INDEX IF EXISTS go_image_content_sha256_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS go_image_content_sha256_idx ON go_image (content_sha256) WHERE deleted_at IS NULL;
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS go_image_position_idx;
+DROP INDEX IF EXISTS go_image_taken_at_idx;
+
+ALTER TABLE go_image DROP COLUMN IF EXISTS longitude;
+ALTER TABLE go_image DROP COLUMN IF EXISTS latitude;
+ALTER TABLE go_image DROP COLUMN IF EXISTS orientation;
+ALTER TABLE go_image DROP COLUMN IF EXISTS camera_model;
+ALTER TABLE go_image DROP COLUMN IF EXISTS camera_make;
+ALTER TABLE go_image DROP COLUMN IF EXISTS taken_at;
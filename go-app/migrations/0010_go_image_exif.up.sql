@@ -0,0 +1,10 @@
+-- Metadata parsed from the EXIF segment of JPEG images.
+ALTER TABLE go_image ADD COLUMN IF NOT EXISTS taken_at TIMESTAMPTZ;
+ALTER TABLE go_image ADD COLUMN IF NOT EXISTS camera_make TEXT NOT NULL DEFAULT '';
+ALTER TABLE go_image ADD COLUMN IF NOT EXISTS camera_model TEXT NOT NULL DEFAULT '';
+ALTER TABLE go_image ADD COLUMN IF NOT EXISTS orientation INT NOT NULL DEFAULT 0;
+ALTER TABLE go_image ADD COLUMN IF NOT EXISTS latitude DOUBLE PRECISION;
+ALTER TABLE go_image ADD COLUMN IF NOT EXISTS longitude DOUBLE PRECISION;
+
+CREATE INDEX IF NOT EXISTS go_image_taken_at_idx ON go_image (taken_at) WHERE taken_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS go_image_position_idx ON go_image (latitude, longitude) WHERE latitude IS NOT NULL;
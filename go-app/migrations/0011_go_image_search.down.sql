@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS go_image_search_trgm_idx;
+DROP INDEX IF EXISTS go_image_search_tsv_idx;
+
+DROP FUNCTION IF EXISTS go_image_search_text(TEXT, TEXT[]);
@@ -0,0 +1,12 @@
+-- Trigram indexes make partial file name and tag matches fast.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- array_to_string is only STABLE, so the indexed expression needs an IMMUTABLE wrapper.
+CREATE OR REPLACE FUNCTION go_image_search_text(file_name TEXT, tags TEXT[]) RETURNS TEXT
+    LANGUAGE sql IMMUTABLE PARALLEL SAFE
+    AS $$ SELECT file_name || ' ' || array_to_string(tags, ' ') $$;
+
+CREATE INDEX IF NOT EXISTS go_image_search_tsv_idx ON go_image
+    USING gin (to_tsvector('simple', go_image_search_text(file_name, tags)));
+CREATE INDEX IF NOT EXISTS go_image_search_trgm_idx ON go_image
+    USING gin (go_image_search_text(file_name, tags) gin_trgm_ops);
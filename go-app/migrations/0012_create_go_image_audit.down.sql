@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS go_image_audit;
+DROP FUNCTION IF EXISTS go_image_audit_append_only();
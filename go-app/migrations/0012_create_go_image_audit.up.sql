@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS go_image_audit (
+    id         BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    actor      TEXT NOT NULL,
+    action     TEXT NOT NULL,
+    image_uuid TEXT NOT NULL,
+    client_ip  TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    trace_id   TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS go_image_audit_image_uuid_idx ON go_image_audit (image_uuid, id);
+CREATE INDEX IF NOT EXISTS go_image_audit_actor_idx ON go_image_audit (actor, id);
+CREATE INDEX IF NOT EXISTS go_image_audit_created_at_idx ON go_image_audit (created_at);
+
+-- The audit log is append-only: reject every change to existing records.
+CREATE OR REPLACE FUNCTION go_image_audit_append_only() RETURNS trigger
+    LANGUAGE plpgsql
+    AS $$ BEGIN RAISE EXCEPTION 'go_image_audit is append-only'; END $$;
+
+DROP TRIGGER IF EXISTS go_image_audit_no_update ON go_image_audit;
+CREATE TRIGGER go_image_audit_no_update BEFORE UPDATE OR DELETE ON go_image_audit
+    FOR EACH ROW EXECUTE FUNCTION go_image_audit_append_only();
+
+DROP TRIGGER IF EXISTS go_image_audit_no_truncate ON go_image_audit;
+CREATE TRIGGER go_image_audit_no_truncate BEFORE TRUNCATE ON go_image_audit
+    FOR EACH STATEMENT EXECUTE FUNCTION go_image_audit_append_only();
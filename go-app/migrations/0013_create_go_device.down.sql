@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS go_device_seed;
+DROP TABLE IF EXISTS go_device;
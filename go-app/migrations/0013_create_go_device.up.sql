@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS go_device (
+    uuid       UUID NOT NULL,
+    mac        TEXT NOT NULL,
+    firmware   TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CONSTRAINT go_device_pkey PRIMARY KEY (uuid),
+    CONSTRAINT go_device_mac_key UNIQUE (mac)
+);
+
+-- Seeds applied to the registry; each is applied only once.
+CREATE TABLE IF NOT EXISTS go_device_seed (
+    name       TEXT PRIMARY KEY,
+    applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
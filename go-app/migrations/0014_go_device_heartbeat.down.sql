@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS go_device_online_idx;
+
+ALTER TABLE go_device DROP COLUMN IF EXISTS last_ip;
+ALTER TABLE go_device DROP COLUMN IF EXISTS last_seen;
+ALTER TABLE go_device DROP COLUMN IF EXISTS status;
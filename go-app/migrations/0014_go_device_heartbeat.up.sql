@@ -0,0 +1,7 @@
+-- Devices are offline until their first heartbeat.
+ALTER TABLE go_device ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'offline';
+ALTER TABLE go_device ADD COLUMN IF NOT EXISTS last_seen TIMESTAMPTZ;
+ALTER TABLE go_device ADD COLUMN IF NOT EXISTS last_ip TEXT NOT NULL DEFAULT '';
+
+-- The offline detector only looks at online devices.
+CREATE INDEX IF NOT EXISTS go_device_online_idx ON go_device (last_seen) WHERE status = 'online';
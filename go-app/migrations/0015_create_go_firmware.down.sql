@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS go_firmware_rollout;
+DROP TABLE IF EXISTS go_firmware_campaign;
+DROP TABLE IF EXISTS go_firmware_release;
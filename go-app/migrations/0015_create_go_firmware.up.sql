@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS go_firmware_release (
+    version    TEXT PRIMARY KEY,
+    object_key TEXT NOT NULL,
+    sha256     TEXT NOT NULL,
+    size       BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS go_firmware_campaign (
+    id         BIGSERIAL PRIMARY KEY,
+    version    TEXT NOT NULL REFERENCES go_firmware_release (version),
+    percent    INT NOT NULL DEFAULT 0,
+    state      TEXT NOT NULL DEFAULT 'active',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- One row per device targeted by a campaign, fixed when the campaign is created.
+CREATE TABLE IF NOT EXISTS go_firmware_rollout (
+    campaign_id BIGINT NOT NULL REFERENCES go_firmware_campaign (id) ON DELETE CASCADE,
+    device_uuid UUID NOT NULL REFERENCES go_device (uuid) ON DELETE CASCADE,
+    state       TEXT NOT NULL DEFAULT 'pending',
+    error       TEXT NOT NULL DEFAULT '',
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (campaign_id, device_uuid)
+);
+
+-- Devices polling for their target version only look at unfinished rollouts.
+CREATE INDEX IF NOT EXISTS go_firmware_rollout_device_idx ON go_firmware_rollout (device_uuid)
+    WHERE state IN ('pending', 'in_progress');
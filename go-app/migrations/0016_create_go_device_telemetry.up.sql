@@ -0,0 +1,11 @@
+-- Numeric readings pushed by devices; resent batches hit the primary key and are skipped.
+CREATE TABLE IF NOT EXISTS go_device_telemetry (
+    device_uuid UUID NOT NULL REFERENCES go_device (uuid) ON DELETE CASCADE,
+    metric      TEXT NOT NULL,
+    time        TIMESTAMPTZ NOT NULL,
+    value       DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (device_uuid, metric, time)
+);
+
+-- Range queries over all metrics of a device.
+CREATE INDEX IF NOT EXISTS go_device_telemetry_time_idx ON go_device_telemetry (device_uuid, time);
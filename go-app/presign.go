@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// presigner returns the object store as a Presigner, or responds with 501
+// when the configured backend cannot sign URLs.
+func (h *handler) presigner(c *gin.Context) (Presigner, bool) {
+	p, ok := h.store.(Presigner)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"message": "presigned URLs require the s3 storage backend"})
+		return nil, false
+	}
+
+	return p, true
+}
+
+// presignTTL returns how long presigned URLs stay valid.
+func (h *handler) presignTTL() time.Duration {
+	return time.Duration(h.config.S3Config.PresignTTLSeconds) * time.Second
+}
+
+// presignUpload issues a presigned PUT URL so the client can upload straight to the bucket.
+func (h *handler) presignUpload(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP POST /api/images/presign")
+	defer span.End()
+
+	p, ok := h.presigner(c)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		FileName    string `json:"fileName"`
+		ContentType string `json:"contentType"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.FileName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "fileName is required"})
+		return
+	}
+	if body.ContentType == "" {
+		body.ContentType = "application/octet-stream"
+	}
+
+	// Reserve the image UUID now; the row is only created by the finalize call.
+	image := NewImage(filepath.Base(body.FileName), 0, time.Now())
+	image.ObjectKey = uploadKey(image)
+
+	ttl := h.presignTTL()
+	url, err := p.PresignPut(ctx, image.ObjectKey, body.ContentType, ttl)
+	if err != nil {
+		log.Printf("PresignPut failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"uuid":      image.ImageUUID,
+		"fileName":  image.FileName,
+		"objectKey": image.ObjectKey,
+		"method":    http.MethodPut,
+		"url":       url,
+		"headers":   gin.H{"Content-Type": body.ContentType},
+		"expiresAt": time.Now().Add(ttl),
+	})
+}
+
+// finalizeUpload creates the image row for an object uploaded with a presigned URL
+// and queues it for processing. Calling it again returns the same image.
+func (h *handler) finalizeUpload(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP POST /api/images/:uuid/finalize")
+	defer span.End()
+
+	id, ok := imageUUID(c)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		FileName string `json:"fileName"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.FileName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "fileName is required"})
+		return
+	}
+
+	// A retried finalize must not create a second row.
+	existing, err := h.images.Get(ctx, id)
+	if err == nil {
+		c.JSON(http.StatusOK, gin.H{"message": "already finalized", "duplicate": false, "metadata": existing})
+		return
+	}
+	if !errors.Is(err, ErrImageNotFound) {
+		log.Printf("images.Get failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	// Rebuild the key the upload was signed for from the UUID and file name.
+	fileName := filepath.Base(body.FileName)
+	key := uploadKey(&Image{ImageUUID: id, FileName: fileName})
+
+	// Hash the uploaded object to detect duplicates.
+	info, hash, err := hashObject(ctx, h.store, key)
+	if errors.Is(err, ErrObjectNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "object not uploaded yet"})
+		return
+	}
+	if err != nil {
+		log.Printf("hashObject failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal error"})
+		return
+	}
+
+	// Size and tags come from the object that actually landed in the bucket.
+	image := newObjectImage(info)
+	image.FileName = fileName
+	image.ContentHash = hash
+
+	// Save the image metadata and queue it for processing.
+	existing, duplicate, err := h.ingest(ctx, image)
+	if err != nil {
+		log.Printf("ingest failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	if duplicate {
+		// The content is already stored under the existing image's key, unless
+		// a bucket event registered this very object first.
+		if existing.ObjectKey != image.ObjectKey {
+			if err := h.store.Delete(ctx, image.ObjectKey); err != nil {
+				log.Printf("store.Delete failed: %v", err)
+			}
+		}
+		h.audit(ctx, c, auditUpload, existing.ImageUUID)
+		c.JSON(http.StatusOK, gin.H{"message": "duplicate", "duplicate": true, "metadata": existing})
+		return
+	}
+
+	h.audit(ctx, c, auditUpload, image.ImageUUID)
+	c.JSON(http.StatusAccepted, gin.H{"message": "queued", "duplicate": false, "metadata": image})
+}
+
+// presignDownload issues a presigned GET URL for the image content.
+func (h *handler) presignDownload(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP GET /api/images/:uuid/url")
+	defer span.End()
+
+	p, ok := h.presigner(c)
+	if !ok {
+		return
+	}
+
+	id, ok := imageUUID(c)
+	if !ok {
+		return
+	}
+
+	image, err := h.images.Get(ctx, id)
+	if errors.Is(err, ErrImageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "image not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("images.Get failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	ttl := h.presignTTL()
+	url, err := p.PresignGet(ctx, image.ObjectKey, ttl)
+	if err != nil {
+		log.Printf("PresignGet failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal error"})
+		return
+	}
+
+	// The URL grants the download, so it is audited as one.
+	h.audit(ctx, c, auditDownload, image.ImageUUID)
+	c.JSON(http.StatusOK, gin.H{
+		"uuid":      image.ImageUUID,
+		"method":    http.MethodGet,
+		"url":       url,
+		"expiresAt": time.Now().Add(ttl),
+	})
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// reconcileLockID is the Postgres advisory lock key that lets only one replica
+// reconcile the bucket at a time.
+const reconcileLockID int64 = 0x676f5f7265636f6e
+
+// reconcileResult counts what a reconciliation pass found and changed.
+type reconcileResult struct {
+	// Objects is the number of objects in the bucket, derivatives excluded.
+	Objects int
+
+	// Imported is the number of objects that got a new image row.
+	Imported int
+
+	// Missing is the number of images newly marked as missing.
+	Missing int
+
+	// Restored is the number of missing images whose object came back.
+	Restored int
+
+	// MissingTotal is the number of images missing after the pass.
+	MissingTotal int
+}
+
+// reconcile lists the whole bucket and brings the image rows in line with it:
+// objects without a row are imported and queued for processing, rows whose
+// object vanished are marked as missing, and missing rows whose object came
+// back are queued again. With dryRun it only reports what it would do.
+func (h *handler) reconcile(ctx context.Context, dryRun bool) (*reconcileResult, error) {
+	// Create a new ROOT span to record and trace the reconciliation.
+	ctx, span := tracer.Start(ctx, "JOB reconcile bucket")
+	defer span.End()
+
+	// Record metrics for this operation
+	start := time.Now()
+	defer func() {
+		h.metrics.duration.With(prometheus.Labels{"op": "reconcile"}).Observe(time.Since(start).Seconds())
+	}()
+
+	// Collect the keys first; the store pages through the listing for us.
+	objects := make(map[string]ObjectInfo)
+	err := h.store.List(ctx, "", func(o *ObjectInfo) error {
+		// Derivatives and firmware artifacts never get an image row of their own.
+		if !managedObject(o.Key) {
+			objects[o.Key] = *o
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("store.List failed: %w", err)
+	}
+
+	// Load the rows after the listing so that every listed upload already has its row.
+	images, err := h.allImages(ctx, ImageFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	// Objects of deleted images stay in the bucket until they are purged.
+	deleted, err := h.allImages(ctx, ImageFilter{Deleted: true})
+	if err != nil {
+		return nil, err
+	}
+
+	res := &reconcileResult{Objects: len(objects)}
+	known := make(map[string]bool, len(images)+len(deleted))
+	for _, image := range deleted {
+		known[image.ObjectKey] = true
+	}
+	for _, image := range images {
+		known[image.ObjectKey] = true
+		_, found := objects[image.ObjectKey]
+
+		switch {
+		case !found && image.Status == StatusMissing:
+			res.MissingTotal++
+		case !found:
+			// Rows created during the listing may point to objects it did not see.
+			if image.ProcessedAt.After(start) {
+				continue
+			}
+			res.Missing++
+			res.MissingTotal++
+			log.Printf("image %s: object %s is missing", image.ImageUUID, image.ObjectKey)
+			if dryRun {
+				continue
+			}
+			if err := h.images.UpdateStatus(ctx, image.ImageUUID, StatusMissing, "object not found in bucket"); err != nil {
+				log.Printf("images.UpdateStatus failed: %v", err)
+			}
+		case image.Status == StatusMissing:
+			res.Restored++
+			log.Printf("image %s: object %s is back", image.ImageUUID, image.ObjectKey)
+			if dryRun {
+				continue
+			}
+			if err := h.requeue(ctx, image.ImageUUID, "object found in bucket again"); err != nil {
+				log.Printf("requeue failed: %v", err)
+			}
+		}
+	}
+
+	// Import the remaining objects in key order so the log is easy to follow.
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		if !known[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		o := objects[key]
+
+		// Objects written during the listing are left for the next pass.
+		if o.LastModified.After(start) {
+			continue
+		}
+		res.Imported++
+		log.Printf("importing object %s", key)
+		if dryRun {
+			continue
+		}
+
+		// The worker sniffs the content type and hashes the object when it processes it.
+		if _, _, err := h.ingest(ctx, newObjectImage(&o)); err != nil {
+			log.Printf("ingest failed: %v", err)
+		}
+	}
+
+	if !dryRun {
+		h.metrics.reconciled.With(prometheus.Labels{"result": "imported"}).Add(float64(res.Imported))
+		h.metrics.reconciled.With(prometheus.Labels{"result": "missing"}).Add(float64(res.Missing))
+		h.metrics.reconciled.With(prometheus.Labels{"result": "restored"}).Add(float64(res.Restored))
+		h.metrics.bucketObjects.Set(float64(res.Objects))
+		h.metrics.missingImages.Set(float64(res.MissingTotal))
+	}
+
+	return res, nil
+}
+
+// requeue sends the image back to uploaded and queues it for processing.
+func (h *handler) requeue(ctx context.Context, id string, reason string) error {
+	if err := h.images.UpdateStatus(ctx, id, StatusUploaded, reason); err != nil {
+		return fmt.Errorf("images.UpdateStatus failed: %w", err)
+	}
+
+	if err := h.jobs.Enqueue(ctx, id); err != nil {
+		return fmt.Errorf("jobs.Enqueue failed: %w", err)
+	}
+
+	return nil
+}
+
+// withTryLock runs fn unless another replica holds the advisory lock.
+// It reports whether fn ran. Without Postgres there is only one replica.
+func (h *handler) withTryLock(ctx context.Context, lockID int64, fn func() error) (bool, error) {
+	if h.dbpool == nil {
+		return true, fn()
+	}
+
+	// Advisory locks belong to a session, so lock and unlock on the same connection.
+	conn, err := h.dbpool.Acquire(ctx)
+	if err != nil {
+		return false, fmt.Errorf("dbpool.Acquire failed: %w", err)
+	}
+	defer conn.Release()
+
+	var locked bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", lockID).Scan(&locked); err != nil {
+		return false, fmt.Errorf("pg_try_advisory_lock failed: %w", err)
+	}
+	if !locked {
+		return false, nil
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", lockID)
+
+	return true, fn()
+}
+
+// reconcileLoop periodically reconciles the bucket until the context is done.
+func (h *handler) reconcileLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(h.config.Reconcile.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		ran, err := h.withTryLock(ctx, reconcileLockID, func() error {
+			res, err := h.reconcile(ctx, false)
+			if err == nil {
+				log.Printf("reconciled %d objects: %d imported, %d missing, %d restored",
+					res.Objects, res.Imported, res.Missing, res.Restored)
+			}
+			return err
+		})
+		if err != nil {
+			log.Printf("reconcile failed: %v", err)
+		} else if !ran {
+			log.Printf("reconcile skipped: another replica is reconciling")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runReconcile handles the "reconcile [-dry-run]" subcommand, a single
+// reconciliation pass for imports of objects copied into the bucket by hand.
+func runReconcile(c *Config, args []string) {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "Only report the changes without applying them")
+	flags.Parse(args)
+
+	h := newCommandHandler(c)
+
+	ctx := context.Background()
+
+	var res *reconcileResult
+	ran, err := h.withTryLock(ctx, reconcileLockID, func() error {
+		var err error
+		res, err = h.reconcile(ctx, *dryRun)
+		return err
+	})
+	if err != nil {
+		log.Fatalf("reconcile failed: %v", err)
+	}
+	if !ran {
+		log.Fatalf("another replica is reconciling the bucket, try again later")
+	}
+
+	if *dryRun {
+		log.Printf("dry run: %d objects, %d would be imported, %d would be marked missing, %d would be restored",
+			res.Objects, res.Imported, res.Missing, res.Restored)
+		return
+	}
+	log.Printf("reconciled %d objects: %d imported, %d missing, %d restored",
+		res.Objects, res.Imported, res.Missing, res.Restored)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrImageNotFound is returned when no image matches the given UUID.
+var ErrImageNotFound = errors.New("image not found")
+
+// ErrDuplicateContent is returned when another image already has the same content hash.
+var ErrDuplicateContent = errors.New("duplicate image content")
+
+// ErrDerivativeNotFound is returned when the image has no derivative of the requested size.
+var ErrDerivativeNotFound = errors.New("derivative not found")
+
+// ImageFilter narrows down the images returned by List and Count.
+type ImageFilter struct {
+	// Status matches the processing status exactly.
+	Status string
+
+	// ContentType matches the MIME type exactly.
+	ContentType string
+
+	// Tag matches images that carry the tag.
+	Tag string
+
+	// Size matches the size bucket, small or large, by file size.
+	Size string
+
+	// ObjectKey matches the object store key exactly.
+	ObjectKey string
+
+	// ProcessedFrom is the inclusive lower bound for processed_at.
+	ProcessedFrom *time.Time
+
+	// ProcessedTo is the exclusive upper bound for processed_at.
+	ProcessedTo *time.Time
+
+	// LastModifiedTo is the exclusive upper bound for last_modified.
+	LastModifiedTo *time.Time
+
+	// TakenFrom is the inclusive lower bound for the EXIF capture time.
+	TakenFrom *time.Time
+
+	// TakenTo is the exclusive upper bound for the EXIF capture time.
+	TakenTo *time.Time
+
+	// Bounds matches images whose GPS position lies within the box.
+	Bounds *GeoBounds
+
+	// Deleted selects tombstoned images instead of live ones.
+	Deleted bool
+
+	// DeletedTo is the exclusive upper bound for deleted_at.
+	DeletedTo *time.Time
+
+	// Limit is the maximum number of images to return; zero means no limit.
+	Limit int
+
+	// Offset is the number of images to skip.
+	Offset int
+}
+
+// GeoBounds is a latitude and longitude box in decimal degrees. A box with
+// MinLon greater than MaxLon crosses the antimeridian.
+type GeoBounds struct {
+	MinLat, MinLon, MaxLat, MaxLon float64
+}
+
+// contains reports whether the position lies within the box.
+func (b *GeoBounds) contains(lat, lon float64) bool {
+	if lat < b.MinLat || lat > b.MaxLat {
+		return false
+	}
+	if b.MinLon <= b.MaxLon {
+		return lon >= b.MinLon && lon <= b.MaxLon
+	}
+
+	return lon >= b.MinLon || lon <= b.MaxLon
+}
+
+// TagCount is the number of images carrying a tag.
+type TagCount struct {
+	// Tag is the tag name.
+	Tag string `json:"tag"`
+
+	// Count is the number of images with the tag.
+	Count int64 `json:"count"`
+}
+
+// ImageRepository stores image metadata. Deleted images stay as tombstones
+// until they are purged; only List, Count, Restore and Delete see them.
+type ImageRepository interface {
+	// Insert saves a new image. It returns ErrDuplicateContent when an image
+	// with the same content hash already exists.
+	Insert(ctx context.Context, image *Image) error
+
+	// Get loads a single image by its UUID.
+	Get(ctx context.Context, id string) (*Image, error)
+
+	// GetByHash loads the image with the given content hash.
+	GetByHash(ctx context.Context, hash string) (*Image, error)
+
+	// SetContentHash stores the content hash of an image ingested before hashing existed.
+	// It returns ErrDuplicateContent when another image already has the hash.
+	SetContentHash(ctx context.Context, id string, hash string) error
+
+	// List returns the images matching the filter, newest first.
+	List(ctx context.Context, f ImageFilter) ([]*Image, error)
+
+	// Count returns the number of images matching the filter, ignoring Limit and Offset.
+	Count(ctx context.Context, f ImageFilter) (int64, error)
+
+	// UpdateStatus moves the image to a new status and records the transition with its reason.
+	// It returns ErrInvalidTransition when the current status does not allow the move.
+	UpdateStatus(ctx context.Context, id string, status string, reason string) error
+
+	// History returns the status transitions of the image, oldest first.
+	History(ctx context.Context, id string) ([]ImageEvent, error)
+
+	// UpdateDetails saves the size, content type, dimensions and processing time of the image.
+	UpdateDetails(ctx context.Context, image *Image) error
+
+	// SoftDelete tombstones the image and returns it.
+	SoftDelete(ctx context.Context, id string) (*Image, error)
+
+	// Restore clears the tombstone of a deleted image and returns it. It returns
+	// ErrDuplicateContent when a live image has the same content by now.
+	Restore(ctx context.Context, id string) (*Image, error)
+
+	// Delete removes the image for good, whether it is live or tombstoned.
+	Delete(ctx context.Context, id string) error
+
+	// AddTags adds the tags the image does not have yet and returns the updated image.
+	AddTags(ctx context.Context, id string, tags []string) (*Image, error)
+
+	// RemoveTags removes the tags from the image and returns the updated image.
+	RemoveTags(ctx context.Context, id string, tags []string) (*Image, error)
+
+	// TagCounts returns every tag with the number of images carrying it, most used first.
+	TagCounts(ctx context.Context) ([]TagCount, error)
+
+	// Search returns a page of the images matching the text query and the
+	// filter, best matches first, with facet counts over all matches.
+	Search(ctx context.Context, q string, f ImageFilter) (*SearchResult, error)
+}
+
+// DerivativeRepository stores the derivatives generated for images.
+type DerivativeRepository interface {
+	// Upsert saves the derivative, replacing an existing one with the same size and mode.
+	Upsert(ctx context.Context, d *Derivative) error
+
+	// Get loads the derivative of the given size.
+	Get(ctx context.Context, imageID string, size int) (*Derivative, error)
+
+	// List returns all derivatives of the image, smallest first.
+	List(ctx context.Context, imageID string) ([]*Derivative, error)
+
+	// Delete removes all derivatives of the image.
+	Delete(ctx context.Context, imageID string) error
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryImageRepository keeps image metadata in memory; it is meant for tests and demos.
+type memoryImageRepository struct {
+	mu     sync.RWMutex
+	images map[string]*Image
+	events map[string][]ImageEvent
+}
+
+// newMemoryImageRepository returns an empty in-memory repository.
+func newMemoryImageRepository() *memoryImageRepository {
+	return &memoryImageRepository{images: make(map[string]*Image), events: make(map[string][]ImageEvent)}
+}
+
+// Insert saves a copy of the image.
+func (r *memoryImageRepository) Insert(ctx context.Context, image *Image) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if image.ContentHash != "" && r.byHash(image.ContentHash) != nil {
+		return ErrDuplicateContent
+	}
+
+	r.images[image.ImageUUID] = cloneImage(image)
+	r.events[image.ImageUUID] = []ImageEvent{{
+		ImageUUID: image.ImageUUID,
+		ToStatus:  image.Status,
+		Reason:    "image created",
+		CreatedAt: time.Now(),
+	}}
+	return nil
+}
+
+// Get returns a copy of the image.
+func (r *memoryImageRepository) Get(ctx context.Context, id string) (*Image, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	image, ok := r.live(id)
+	if !ok {
+		return nil, ErrImageNotFound
+	}
+
+	return cloneImage(image), nil
+}
+
+// GetByHash returns a copy of the image with the given content hash.
+func (r *memoryImageRepository) GetByHash(ctx context.Context, hash string) (*Image, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	image := r.byHash(hash)
+	if image == nil {
+		return nil, ErrImageNotFound
+	}
+
+	return cloneImage(image), nil
+}
+
+// SetContentHash stores the content hash of the image.
+func (r *memoryImageRepository) SetContentHash(ctx context.Context, id string, hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	image, ok := r.live(id)
+	if !ok {
+		return ErrImageNotFound
+	}
+	if other := r.byHash(hash); other != nil && other.ImageUUID != id {
+		return ErrDuplicateContent
+	}
+
+	image.ContentHash = hash
+	return nil
+}
+
+// byHash finds the live image with the content hash; the caller must hold the lock.
+func (r *memoryImageRepository) byHash(hash string) *Image {
+	for _, image := range r.images {
+		if image.ContentHash == hash && image.DeletedAt == nil {
+			return image
+		}
+	}
+
+	return nil
+}
+
+// List returns copies of the matching images, newest first.
+func (r *memoryImageRepository) List(ctx context.Context, f ImageFilter) ([]*Image, error) {
+	images := r.match(f)
+
+	// Apply the same ordering as the Postgres repository.
+	sort.Slice(images, func(i, j int) bool {
+		if !images[i].ProcessedAt.Equal(images[j].ProcessedAt) {
+			return images[i].ProcessedAt.After(images[j].ProcessedAt)
+		}
+		return images[i].ImageUUID < images[j].ImageUUID
+	})
+
+	if f.Offset >= len(images) {
+		return []*Image{}, nil
+	}
+	images = images[f.Offset:]
+	if f.Limit > 0 && f.Limit < len(images) {
+		images = images[:f.Limit]
+	}
+
+	return images, nil
+}
+
+// Count returns the number of matching images.
+func (r *memoryImageRepository) Count(ctx context.Context, f ImageFilter) (int64, error) {
+	return int64(len(r.match(f))), nil
+}
+
+// UpdateStatus moves the image to a new status if the transition is allowed.
+func (r *memoryImageRepository) UpdateStatus(ctx context.Context, id string, status string, reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	image, ok := r.live(id)
+	if !ok {
+		return ErrImageNotFound
+	}
+
+	if !canTransition(image.Status, status) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, image.Status, status)
+	}
+
+	r.events[id] = append(r.events[id], ImageEvent{
+		ImageUUID:  id,
+		FromStatus: image.Status,
+		ToStatus:   status,
+		Reason:     reason,
+		CreatedAt:  time.Now(),
+	})
+	image.Status = status
+	image.StatusReason = reason
+	return nil
+}
+
+// History returns the status transitions of the image.
+func (r *memoryImageRepository) History(ctx context.Context, id string) ([]ImageEvent, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return slices.Clone(r.events[id]), nil
+}
+
+// UpdateDetails saves the processing results of the image.
+func (r *memoryImageRepository) UpdateDetails(ctx context.Context, image *Image) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.live(image.ImageUUID)
+	if !ok {
+		return ErrImageNotFound
+	}
+
+	stored.FileSize = image.FileSize
+	stored.ContentType = image.ContentType
+	stored.Width = image.Width
+	stored.Height = image.Height
+	stored.ColorModel = image.ColorModel
+	stored.ProcessedAt = image.ProcessedAt
+	stored.TakenAt = clonePtr(image.TakenAt)
+	stored.CameraMake = image.CameraMake
+	stored.CameraModel = image.CameraModel
+	stored.Orientation = image.Orientation
+	stored.Latitude = clonePtr(image.Latitude)
+	stored.Longitude = clonePtr(image.Longitude)
+	return nil
+}
+
+// SoftDelete tombstones the live image.
+func (r *memoryImageRepository) SoftDelete(ctx context.Context, id string) (*Image, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	image, ok := r.live(id)
+	if !ok {
+		return nil, ErrImageNotFound
+	}
+
+	now := time.Now()
+	image.DeletedAt = &now
+	return cloneImage(image), nil
+}
+
+// Restore clears the tombstone of the deleted image.
+func (r *memoryImageRepository) Restore(ctx context.Context, id string) (*Image, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	image, ok := r.images[id]
+	if !ok || image.DeletedAt == nil {
+		return nil, ErrImageNotFound
+	}
+	if image.ContentHash != "" && r.byHash(image.ContentHash) != nil {
+		return nil, ErrDuplicateContent
+	}
+
+	image.DeletedAt = nil
+	return cloneImage(image), nil
+}
+
+// live returns the stored image unless it is missing or tombstoned; the caller must hold the lock.
+func (r *memoryImageRepository) live(id string) (*Image, bool) {
+	image, ok := r.images[id]
+	if !ok || image.DeletedAt != nil {
+		return nil, false
+	}
+
+	return image, true
+}
+
+// Delete removes the image, whether it is live or tombstoned.
+func (r *memoryImageRepository) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.images[id]; !ok {
+		return ErrImageNotFound
+	}
+
+	delete(r.images, id)
+	delete(r.events, id)
+	return nil
+}
+
+// AddTags appends the missing tags to the image.
+func (r *memoryImageRepository) AddTags(ctx context.Context, id string, tags []string) (*Image, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	image, ok := r.live(id)
+	if !ok {
+		return nil, ErrImageNotFound
+	}
+
+	for _, tag := range tags {
+		if !slices.Contains(image.Tags, tag) {
+			image.Tags = append(image.Tags, tag)
+		}
+	}
+
+	return cloneImage(image), nil
+}
+
+// RemoveTags removes the tags from the image.
+func (r *memoryImageRepository) RemoveTags(ctx context.Context, id string, tags []string) (*Image, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	image, ok := r.live(id)
+	if !ok {
+		return nil, ErrImageNotFound
+	}
+
+	image.Tags = slices.DeleteFunc(image.Tags, func(tag string) bool {
+		return slices.Contains(tags, tag)
+	})
+
+	return cloneImage(image), nil
+}
+
+// TagCounts counts the images per tag, most used first.
+func (r *memoryImageRepository) TagCounts(ctx context.Context) ([]TagCount, error) {
+	r.mu.RLock()
+	byTag := make(map[string]int64)
+	for _, image := range r.images {
+		if image.DeletedAt != nil {
+			continue
+		}
+		for _, tag := range image.Tags {
+			byTag[tag]++
+		}
+	}
+	r.mu.RUnlock()
+
+	counts := make([]TagCount, 0, len(byTag))
+	for tag, n := range byTag {
+		counts = append(counts, TagCount{Tag: tag, Count: n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Tag < counts[j].Tag
+	})
+
+	return counts, nil
+}
+
+// Search matches the query against the file name and tags, preferring images
+// that contain the whole query over those that only contain all of its words.
+func (r *memoryImageRepository) Search(ctx context.Context, q string, f ImageFilter) (*SearchResult, error) {
+	all := f
+	all.Limit, all.Offset = 0, 0
+
+	q = strings.ToLower(q)
+	words := strings.Fields(q)
+
+	rank := make(map[string]int)
+	hits := []*Image{}
+	for _, image := range r.match(all) {
+		doc := strings.ToLower(image.FileName + " " + strings.Join(image.Tags, " "))
+		switch {
+		case strings.Contains(doc, q):
+			rank[image.ImageUUID] = 2
+		case !slices.ContainsFunc(words, func(w string) bool { return !strings.Contains(doc, w) }):
+			rank[image.ImageUUID] = 1
+		default:
+			continue
+		}
+		hits = append(hits, image)
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if ri, rj := rank[hits[i].ImageUUID], rank[hits[j].ImageUUID]; ri != rj {
+			return ri > rj
+		}
+		if !hits[i].ProcessedAt.Equal(hits[j].ProcessedAt) {
+			return hits[i].ProcessedAt.After(hits[j].ProcessedAt)
+		}
+		return hits[i].ImageUUID < hits[j].ImageUUID
+	})
+
+	statuses, types, tags, sizes := map[string]int64{}, map[string]int64{}, map[string]int64{}, map[string]int64{}
+	for _, image := range hits {
+		statuses[image.Status]++
+		types[image.ContentType]++
+		sizes[sizeBucket(image.FileSize)]++
+		for _, tag := range image.Tags {
+			tags[tag]++
+		}
+	}
+
+	res := &SearchResult{
+		Total: int64(len(hits)),
+		Facets: SearchFacets{
+			Status:      countFacet(statuses, 0),
+			ContentType: countFacet(types, 0),
+			Tag:         countFacet(tags, maxTagFacets),
+			Size:        countFacet(sizes, 0),
+		},
+	}
+
+	if f.Offset < len(hits) {
+		hits = hits[f.Offset:]
+	} else {
+		hits = []*Image{}
+	}
+	if f.Limit > 0 && f.Limit < len(hits) {
+		hits = hits[:f.Limit]
+	}
+	res.Items = hits
+
+	return res, nil
+}
+
+// match returns copies of all images matching the filter conditions.
+func (r *memoryImageRepository) match(f ImageFilter) []*Image {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	images := []*Image{}
+	for _, image := range r.images {
+		if (image.DeletedAt != nil) != f.Deleted {
+			continue
+		}
+		if f.DeletedTo != nil && (image.DeletedAt == nil || !image.DeletedAt.Before(*f.DeletedTo)) {
+			continue
+		}
+		if f.Status != "" && image.Status != f.Status {
+			continue
+		}
+		if f.ContentType != "" && image.ContentType != f.ContentType {
+			continue
+		}
+		if f.Tag != "" && !slices.Contains(image.Tags, f.Tag) {
+			continue
+		}
+		if f.Size != "" && sizeBucket(image.FileSize) != f.Size {
+			continue
+		}
+		if f.ObjectKey != "" && image.ObjectKey != f.ObjectKey {
+			continue
+		}
+		if f.ProcessedFrom != nil && image.ProcessedAt.Before(*f.ProcessedFrom) {
+			continue
+		}
+		if f.ProcessedTo != nil && !image.ProcessedAt.Before(*f.ProcessedTo) {
+			continue
+		}
+		if f.LastModifiedTo != nil && !image.LastModified.Before(*f.LastModifiedTo) {
+			continue
+		}
+		if f.TakenFrom != nil && (image.TakenAt == nil || image.TakenAt.Before(*f.TakenFrom)) {
+			continue
+		}
+		if f.TakenTo != nil && (image.TakenAt == nil || !image.TakenAt.Before(*f.TakenTo)) {
+			continue
+		}
+		if f.Bounds != nil && (image.Latitude == nil || image.Longitude == nil || !f.Bounds.contains(*image.Latitude, *image.Longitude)) {
+			continue
+		}
+		images = append(images, cloneImage(image))
+	}
+
+	return images
+}
+
+// cloneImage returns a deep copy so callers cannot modify stored images.
+func cloneImage(image *Image) *Image {
+	c := *image
+	c.Tags = slices.Clone(image.Tags)
+	c.DeletedAt = clonePtr(image.DeletedAt)
+	c.TakenAt = clonePtr(image.TakenAt)
+	c.Latitude = clonePtr(image.Latitude)
+	c.Longitude = clonePtr(image.Longitude)
+	return &c
+}
+
+// clonePtr returns a pointer to a copy of the value, or nil.
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	c := *p
+	return &c
+}
+
+// memoryDerivativeRepository keeps derivatives in memory; it is meant for tests and demos.
+type memoryDerivativeRepository struct {
+	mu          sync.RWMutex
+	derivatives map[string][]*Derivative
+}
+
+// newMemoryDerivativeRepository returns an empty in-memory repository.
+func newMemoryDerivativeRepository() *memoryDerivativeRepository {
+	return &memoryDerivativeRepository{derivatives: make(map[string][]*Derivative)}
+}
+
+// Upsert saves a copy of the derivative, replacing the one with the same size and mode.
+func (r *memoryDerivativeRepository) Upsert(ctx context.Context, d *Derivative) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c := *d
+	list := slices.DeleteFunc(r.derivatives[d.ImageUUID], func(e *Derivative) bool {
+		return e.Size == d.Size && e.Mode == d.Mode
+	})
+	list = append(list, &c)
+
+	// Keep the same ordering as the Postgres repository.
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Size != list[j].Size {
+			return list[i].Size < list[j].Size
+		}
+		return list[i].Mode < list[j].Mode
+	})
+	r.derivatives[d.ImageUUID] = list
+
+	return nil
+}
+
+// Get returns a copy of the derivative of the given size.
+func (r *memoryDerivativeRepository) Get(ctx context.Context, imageID string, size int) (*Derivative, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, d := range r.derivatives[imageID] {
+		if d.Size == size {
+			c := *d
+			return &c, nil
+		}
+	}
+
+	return nil, ErrDerivativeNotFound
+}
+
+// List returns copies of all derivatives of the image.
+func (r *memoryDerivativeRepository) List(ctx context.Context, imageID string) ([]*Derivative, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]*Derivative, 0, len(r.derivatives[imageID]))
+	for _, d := range r.derivatives[imageID] {
+		c := *d
+		list = append(list, &c)
+	}
+
+	return list, nil
+}
+
+// Delete removes all derivatives of the image.
+func (r *memoryDerivativeRepository) Delete(ctx context.Context, imageID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.derivatives, imageID)
+	return nil
+}
@@ -0,0 +1,849 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// imageColumns lists the go_image columns in the order scanImage reads them.
+const imageColumns = `image_uuid, last_modified, file_name, object_key, file_size, content_sha256,
+	content_type, width, height, color_model, processed_at, status, status_reason, tags, deleted_at,
+	taken_at, camera_make, camera_model, orientation, latitude, longitude`
+
+// pgImageRepository stores image metadata in Postgres.
+type pgImageRepository struct {
+	// Postgres connection pool
+	dbpool *pgxpool.Pool
+
+	// Table to store images.
+	table string
+
+	// Prometheus metrics
+	metrics *metrics
+}
+
+// newPgImageRepository returns a repository backed by the given table.
+func newPgImageRepository(dbpool *pgxpool.Pool, table string, m *metrics) *pgImageRepository {
+	return &pgImageRepository{dbpool: dbpool, table: table, metrics: m}
+}
+
+// Insert inserts a newly generated image with enhanced metadata into the Postgres database.
+func (r *pgImageRepository) Insert(ctx context.Context, c *Image) error {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL INSERT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	// Prepare the database query to insert a record with enhanced metadata.
+	query := fmt.Sprintf(`INSERT INTO %s (%s) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15,
+		$16, $17, $18, $19, $20, $21)`, r.table, imageColumns)
+
+	// Tags are stored as a text array, never NULL.
+	tags := c.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	// Create the image record and its first history event together.
+	err := pgx.BeginFunc(ctx, r.dbpool, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, query,
+			c.ImageUUID, c.LastModified, c.FileName, c.ObjectKey, c.FileSize, nullIfEmpty(c.ContentHash),
+			c.ContentType, c.Width, c.Height, c.ColorModel, c.ProcessedAt, c.Status, c.StatusReason, tags, c.DeletedAt,
+			c.TakenAt, c.CameraMake, c.CameraModel, c.Orientation, c.Latitude, c.Longitude)
+		if err != nil {
+			return err
+		}
+
+		return insertEvent(ctx, tx, c.ImageUUID, "", c.Status, "image created")
+	})
+	if isUniqueViolation(err) {
+		return ErrDuplicateContent
+	}
+	if err != nil {
+		return fmt.Errorf("pgx.BeginFunc failed: %w", err)
+	}
+
+	// Record the duration of the insert query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return nil
+}
+
+// Get loads a single live image by its UUID from the Postgres database.
+func (r *pgImageRepository) Get(ctx context.Context, id string) (*Image, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL SELECT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := fmt.Sprintf(`SELECT %s FROM %s WHERE image_uuid = $1 AND deleted_at IS NULL`, imageColumns, r.table)
+
+	image, err := scanImage(r.dbpool.QueryRow(ctx, query, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrImageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dbpool.QueryRow failed: %w", err)
+	}
+
+	// Record the duration of the select query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return image, nil
+}
+
+// GetByHash loads the live image with the given content hash.
+func (r *pgImageRepository) GetByHash(ctx context.Context, hash string) (*Image, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL SELECT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := fmt.Sprintf(`SELECT %s FROM %s WHERE content_sha256 = $1 AND deleted_at IS NULL`, imageColumns, r.table)
+
+	image, err := scanImage(r.dbpool.QueryRow(ctx, query, hash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrImageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dbpool.QueryRow failed: %w", err)
+	}
+
+	// Record the duration of the select query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return image, nil
+}
+
+// SetContentHash stores the content hash of the image.
+func (r *pgImageRepository) SetContentHash(ctx context.Context, id string, hash string) error {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL UPDATE")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := fmt.Sprintf(`UPDATE %s SET content_sha256 = $2 WHERE image_uuid = $1 AND deleted_at IS NULL`, r.table)
+
+	tag, err := r.dbpool.Exec(ctx, query, id, hash)
+	if isUniqueViolation(err) {
+		return ErrDuplicateContent
+	}
+	if err != nil {
+		return fmt.Errorf("dbpool.Exec failed: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrImageNotFound
+	}
+
+	// Record the duration of the update query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return nil
+}
+
+// List returns a page of images matching the filter, newest first.
+func (r *pgImageRepository) List(ctx context.Context, f ImageFilter) ([]*Image, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL SELECT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	where, args := imageWhere(f)
+
+	// Select the requested page, newest images first.
+	query := fmt.Sprintf(`SELECT %s FROM %s %s ORDER BY processed_at DESC, image_uuid`, imageColumns, r.table, where)
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if f.Offset > 0 {
+		args = append(args, f.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+
+	rows, err := r.dbpool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("dbpool.Query failed: %w", err)
+	}
+	defer rows.Close()
+
+	images := []*Image{}
+	for rows.Next() {
+		image, err := scanImage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("rows.Scan failed: %w", err)
+		}
+		images = append(images, image)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows.Err failed: %w", err)
+	}
+
+	// Record the duration of the select query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return images, nil
+}
+
+// Count returns the number of images matching the filter.
+func (r *pgImageRepository) Count(ctx context.Context, f ImageFilter) (int64, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL SELECT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	where, args := imageWhere(f)
+
+	var total int64
+	err := r.dbpool.QueryRow(ctx, fmt.Sprintf(`SELECT COUNT(*) FROM %s %s`, r.table, where), args...).Scan(&total)
+	if err != nil {
+		return 0, fmt.Errorf("dbpool.QueryRow failed: %w", err)
+	}
+
+	// Record the duration of the count query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return total, nil
+}
+
+// UpdateStatus moves the image to a new status if the transition is allowed
+// and records the transition in image_events.
+func (r *pgImageRepository) UpdateStatus(ctx context.Context, id string, status string, reason string) error {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL UPDATE")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	err := pgx.BeginFunc(ctx, r.dbpool, func(tx pgx.Tx) error {
+		// Lock the row so concurrent transitions are checked one after another.
+		var from string
+		query := fmt.Sprintf(`SELECT status FROM %s WHERE image_uuid = $1 AND deleted_at IS NULL FOR UPDATE`, r.table)
+		if err := tx.QueryRow(ctx, query, id).Scan(&from); err != nil {
+			return err
+		}
+
+		if !canTransition(from, status) {
+			return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, from, status)
+		}
+
+		query = fmt.Sprintf(`UPDATE %s SET status = $2, status_reason = $3 WHERE image_uuid = $1`, r.table)
+		if _, err := tx.Exec(ctx, query, id, status, reason); err != nil {
+			return err
+		}
+
+		return insertEvent(ctx, tx, id, from, status, reason)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrImageNotFound
+	}
+	if errors.Is(err, ErrInvalidTransition) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("pgx.BeginFunc failed: %w", err)
+	}
+
+	// Record the duration of the update query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return nil
+}
+
+// History returns the status transitions of the image, oldest first.
+func (r *pgImageRepository) History(ctx context.Context, id string) ([]ImageEvent, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL SELECT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	rows, err := r.dbpool.Query(ctx, `SELECT image_uuid, from_status, to_status, reason, created_at
+		FROM image_events WHERE image_uuid = $1 ORDER BY id`, id)
+	if err != nil {
+		return nil, fmt.Errorf("dbpool.Query failed: %w", err)
+	}
+
+	events, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (ImageEvent, error) {
+		var e ImageEvent
+		err := row.Scan(&e.ImageUUID, &e.FromStatus, &e.ToStatus, &e.Reason, &e.CreatedAt)
+		return e, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pgx.CollectRows failed: %w", err)
+	}
+
+	// Record the duration of the select query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return events, nil
+}
+
+// UpdateDetails saves the processing results of the image.
+func (r *pgImageRepository) UpdateDetails(ctx context.Context, c *Image) error {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL UPDATE")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := fmt.Sprintf(`UPDATE %s SET file_size = $2, content_type = $3, width = $4, height = $5,
+		color_model = $6, processed_at = $7, taken_at = $8, camera_make = $9, camera_model = $10,
+		orientation = $11, latitude = $12, longitude = $13 WHERE image_uuid = $1 AND deleted_at IS NULL`, r.table)
+
+	tag, err := r.dbpool.Exec(ctx, query, c.ImageUUID, c.FileSize, c.ContentType, c.Width, c.Height,
+		c.ColorModel, c.ProcessedAt, c.TakenAt, c.CameraMake, c.CameraModel, c.Orientation, c.Latitude, c.Longitude)
+	if err != nil {
+		return fmt.Errorf("dbpool.Exec failed: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrImageNotFound
+	}
+
+	// Record the duration of the update query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return nil
+}
+
+// Delete removes the image with the given UUID from the Postgres database,
+// whether it is live or tombstoned.
+func (r *pgImageRepository) Delete(ctx context.Context, id string) error {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL DELETE")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := fmt.Sprintf(`DELETE FROM %s WHERE image_uuid = $1`, r.table)
+
+	tag, err := r.dbpool.Exec(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("dbpool.Exec failed: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrImageNotFound
+	}
+
+	// Record the duration of the delete query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return nil
+}
+
+// SoftDelete tombstones the live image and returns it.
+func (r *pgImageRepository) SoftDelete(ctx context.Context, id string) (*Image, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL UPDATE")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := fmt.Sprintf(`UPDATE %s SET deleted_at = now()
+		WHERE image_uuid = $1 AND deleted_at IS NULL RETURNING %s`, r.table, imageColumns)
+
+	image, err := scanImage(r.dbpool.QueryRow(ctx, query, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrImageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dbpool.QueryRow failed: %w", err)
+	}
+
+	// Record the duration of the update query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return image, nil
+}
+
+// Restore clears the tombstone of the deleted image and returns it.
+func (r *pgImageRepository) Restore(ctx context.Context, id string) (*Image, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL UPDATE")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := fmt.Sprintf(`UPDATE %s SET deleted_at = NULL
+		WHERE image_uuid = $1 AND deleted_at IS NOT NULL RETURNING %s`, r.table, imageColumns)
+
+	image, err := scanImage(r.dbpool.QueryRow(ctx, query, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrImageNotFound
+	}
+	if isUniqueViolation(err) {
+		return nil, ErrDuplicateContent
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dbpool.QueryRow failed: %w", err)
+	}
+
+	// Record the duration of the update query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return image, nil
+}
+
+// AddTags appends the missing tags to the image, keeping the existing order.
+func (r *pgImageRepository) AddTags(ctx context.Context, id string, tags []string) (*Image, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL UPDATE")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	// Deduplicate while keeping the first position of every tag.
+	query := fmt.Sprintf(`UPDATE %s SET tags = ARRAY(
+		SELECT t FROM unnest(tags || $2::text[]) WITH ORDINALITY AS u(t, n) GROUP BY t ORDER BY min(n)
+	) WHERE image_uuid = $1 AND deleted_at IS NULL RETURNING %s`, r.table, imageColumns)
+
+	image, err := scanImage(r.dbpool.QueryRow(ctx, query, id, tags))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrImageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dbpool.QueryRow failed: %w", err)
+	}
+
+	// Record the duration of the update query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return image, nil
+}
+
+// RemoveTags removes the tags from the image.
+func (r *pgImageRepository) RemoveTags(ctx context.Context, id string, tags []string) (*Image, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL UPDATE")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := fmt.Sprintf(`UPDATE %s SET tags = ARRAY(
+		SELECT t FROM unnest(tags) WITH ORDINALITY AS u(t, n) WHERE t <> ALL($2::text[]) ORDER BY n
+	) WHERE image_uuid = $1 AND deleted_at IS NULL RETURNING %s`, r.table, imageColumns)
+
+	image, err := scanImage(r.dbpool.QueryRow(ctx, query, id, tags))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrImageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dbpool.QueryRow failed: %w", err)
+	}
+
+	// Record the duration of the update query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return image, nil
+}
+
+// TagCounts counts the images per tag.
+func (r *pgImageRepository) TagCounts(ctx context.Context) ([]TagCount, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL SELECT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := fmt.Sprintf(`SELECT t, COUNT(*) FROM %s, unnest(tags) AS t WHERE deleted_at IS NULL
+		GROUP BY t ORDER BY COUNT(*) DESC, t`, r.table)
+
+	rows, err := r.dbpool.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("dbpool.Query failed: %w", err)
+	}
+
+	counts, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (TagCount, error) {
+		var tc TagCount
+		err := row.Scan(&tc.Tag, &tc.Count)
+		return tc, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pgx.CollectRows failed: %w", err)
+	}
+
+	// Record the duration of the select query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return counts, nil
+}
+
+// searchDocument is the text the search indexes of migration 0011 cover.
+const searchDocument = "go_image_search_text(file_name, tags)"
+
+// Search matches the query against file names and tags with full-text search
+// for whole words and trigram matching for partial ones.
+func (r *pgImageRepository) Search(ctx context.Context, q string, f ImageFilter) (*SearchResult, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL SELECT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	where, args := imageWhere(f)
+	order := "processed_at DESC, image_uuid"
+	if q != "" {
+		args = append(args, q, likePattern(q))
+		n := len(args)
+		where += fmt.Sprintf(` AND (to_tsvector('simple', %[1]s) @@ plainto_tsquery('simple', $%[2]d) OR %[1]s ILIKE $%[3]d)`,
+			searchDocument, n-1, n)
+		order = fmt.Sprintf(`ts_rank(to_tsvector('simple', %[1]s), plainto_tsquery('simple', $%[2]d)) + similarity(%[1]s, $%[2]d) DESC, `,
+			searchDocument, n-1) + order
+	}
+
+	// Select the requested page of hits.
+	query := fmt.Sprintf(`SELECT %s FROM %s %s ORDER BY %s`, imageColumns, r.table, where, order)
+	pageArgs := slices.Clone(args)
+	if f.Limit > 0 {
+		pageArgs = append(pageArgs, f.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(pageArgs))
+	}
+	if f.Offset > 0 {
+		pageArgs = append(pageArgs, f.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(pageArgs))
+	}
+
+	rows, err := r.dbpool.Query(ctx, query, pageArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("dbpool.Query failed: %w", err)
+	}
+	hits, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Image, error) {
+		return scanImage(row)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pgx.CollectRows failed: %w", err)
+	}
+
+	res := &SearchResult{Items: hits}
+
+	// Count the status, content type and size facets in a single pass.
+	query = fmt.Sprintf(`SELECT CASE WHEN GROUPING(status) = 0 THEN 'status'
+			WHEN GROUPING(content_type) = 0 THEN 'contentType' ELSE 'size' END,
+			COALESCE(status, content_type, size), COUNT(*)
+		FROM (SELECT status, content_type, CASE WHEN file_size > %d THEN 'large' ELSE 'small' END AS size
+			FROM %s %s) AS hits
+		GROUP BY GROUPING SETS ((status), (content_type), (size))
+		ORDER BY 3 DESC, 2`, largeFileSize, r.table, where)
+
+	rows, err = r.dbpool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("dbpool.Query failed: %w", err)
+	}
+	var facet, value string
+	var count int64
+	_, err = pgx.ForEachRow(rows, []any{&facet, &value, &count}, func() error {
+		res.Facets.add(facet, value, count)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pgx.ForEachRow failed: %w", err)
+	}
+
+	// Every hit has exactly one status.
+	for _, fc := range res.Facets.Status {
+		res.Total += fc.Count
+	}
+
+	// Count the most common tags of the hits.
+	query = fmt.Sprintf(`SELECT t, COUNT(*) FROM %s, unnest(tags) AS t %s
+		GROUP BY t ORDER BY COUNT(*) DESC, t LIMIT %d`, r.table, where, maxTagFacets)
+
+	rows, err = r.dbpool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("dbpool.Query failed: %w", err)
+	}
+	_, err = pgx.ForEachRow(rows, []any{&value, &count}, func() error {
+		res.Facets.add("tag", value, count)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pgx.ForEachRow failed: %w", err)
+	}
+
+	// Record the duration of the select queries.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return res, nil
+}
+
+// likePattern turns the text into an ILIKE pattern matching it anywhere.
+func likePattern(s string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s) + "%"
+}
+
+// isUniqueViolation reports whether the error is a Postgres unique constraint violation.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// nullIfEmpty stores empty strings as NULL, e.g. for columns with a unique index.
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// insertEvent records a status transition in image_events.
+func insertEvent(ctx context.Context, tx pgx.Tx, id, from, to, reason string) error {
+	_, err := tx.Exec(ctx, `INSERT INTO image_events (image_uuid, from_status, to_status, reason)
+		VALUES ($1, $2, $3, $4)`, id, from, to, reason)
+	return err
+}
+
+// imageWhere builds the WHERE clause for the filter, one placeholder per condition.
+func imageWhere(f ImageFilter) (string, []any) {
+	var args []any
+
+	// Tombstoned images are only listed when asked for.
+	conds := []string{"deleted_at IS NULL"}
+	if f.Deleted {
+		conds = []string{"deleted_at IS NOT NULL"}
+	}
+	if f.DeletedTo != nil {
+		args = append(args, *f.DeletedTo)
+		conds = append(conds, fmt.Sprintf("deleted_at < $%d", len(args)))
+	}
+	if f.Status != "" {
+		args = append(args, f.Status)
+		conds = append(conds, fmt.Sprintf("status = $%d", len(args)))
+	}
+	if f.ContentType != "" {
+		args = append(args, f.ContentType)
+		conds = append(conds, fmt.Sprintf("content_type = $%d", len(args)))
+	}
+	if f.Tag != "" {
+		args = append(args, f.Tag)
+		conds = append(conds, fmt.Sprintf("tags @> ARRAY[$%d]::text[]", len(args)))
+	}
+	if f.Size != "" {
+		op := "<="
+		if f.Size == "large" {
+			op = ">"
+		}
+		conds = append(conds, fmt.Sprintf("file_size %s %d", op, largeFileSize))
+	}
+	if f.ObjectKey != "" {
+		args = append(args, f.ObjectKey)
+		conds = append(conds, fmt.Sprintf("object_key = $%d", len(args)))
+	}
+	if f.ProcessedFrom != nil {
+		args = append(args, *f.ProcessedFrom)
+		conds = append(conds, fmt.Sprintf("processed_at >= $%d", len(args)))
+	}
+	if f.ProcessedTo != nil {
+		args = append(args, *f.ProcessedTo)
+		conds = append(conds, fmt.Sprintf("processed_at < $%d", len(args)))
+	}
+	if f.LastModifiedTo != nil {
+		args = append(args, *f.LastModifiedTo)
+		conds = append(conds, fmt.Sprintf("last_modified < $%d", len(args)))
+	}
+	if f.TakenFrom != nil {
+		args = append(args, *f.TakenFrom)
+		conds = append(conds, fmt.Sprintf("taken_at >= $%d", len(args)))
+	}
+	if f.TakenTo != nil {
+		args = append(args, *f.TakenTo)
+		conds = append(conds, fmt.Sprintf("taken_at < $%d", len(args)))
+	}
+	if b := f.Bounds; b != nil {
+		args = append(args, b.MinLat, b.MaxLat, b.MinLon, b.MaxLon)
+		n := len(args)
+		conds = append(conds, fmt.Sprintf("latitude BETWEEN $%d AND $%d", n-3, n-2))
+
+		// A box crossing the antimeridian has its western edge east of its eastern edge.
+		if b.MinLon <= b.MaxLon {
+			conds = append(conds, fmt.Sprintf("longitude BETWEEN $%d AND $%d", n-1, n))
+		} else {
+			conds = append(conds, fmt.Sprintf("(longitude >= $%d OR longitude <= $%d)", n-1, n))
+		}
+	}
+
+	return "WHERE " + strings.Join(conds, " AND "), args
+}
+
+// scanImage reads a single go_image row selected with imageColumns.
+func scanImage(row pgx.Row) (*Image, error) {
+	var image Image
+	var hash *string
+
+	err := row.Scan(&image.ImageUUID, &image.LastModified, &image.FileName, &image.ObjectKey, &image.FileSize, &hash,
+		&image.ContentType, &image.Width, &image.Height, &image.ColorModel, &image.ProcessedAt, &image.Status,
+		&image.StatusReason, &image.Tags, &image.DeletedAt, &image.TakenAt, &image.CameraMake, &image.CameraModel,
+		&image.Orientation, &image.Latitude, &image.Longitude)
+	if err != nil {
+		return nil, err
+	}
+
+	// Images ingested before hashing have no content hash.
+	if hash != nil {
+		image.ContentHash = *hash
+	}
+
+	return &image, nil
+}
+
+// derivativeColumns lists the go_image_derivative columns in the order scanDerivative reads them.
+const derivativeColumns = `image_uuid, size, mode, object_key, width, height, file_size, content_type, created_at`
+
+// pgDerivativeRepository stores derivatives in Postgres.
+type pgDerivativeRepository struct {
+	// Postgres connection pool
+	dbpool *pgxpool.Pool
+
+	// Prometheus metrics
+	metrics *metrics
+}
+
+// newPgDerivativeRepository returns a repository backed by the go_image_derivative table.
+func newPgDerivativeRepository(dbpool *pgxpool.Pool, m *metrics) *pgDerivativeRepository {
+	return &pgDerivativeRepository{dbpool: dbpool, metrics: m}
+}
+
+// Upsert inserts the derivative or replaces the existing one.
+func (r *pgDerivativeRepository) Upsert(ctx context.Context, d *Derivative) error {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL INSERT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := `INSERT INTO go_image_derivative (` + derivativeColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (image_uuid, size, mode) DO UPDATE SET
+			object_key = EXCLUDED.object_key, width = EXCLUDED.width, height = EXCLUDED.height,
+			file_size = EXCLUDED.file_size, content_type = EXCLUDED.content_type, created_at = EXCLUDED.created_at`
+
+	_, err := r.dbpool.Exec(ctx, query, d.ImageUUID, d.Size, d.Mode, d.ObjectKey,
+		d.Width, d.Height, d.FileSize, d.ContentType, d.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("dbpool.Exec failed: %w", err)
+	}
+
+	// Record the duration of the insert query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return nil
+}
+
+// Get loads the derivative of the given size.
+func (r *pgDerivativeRepository) Get(ctx context.Context, imageID string, size int) (*Derivative, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL SELECT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := `SELECT ` + derivativeColumns + ` FROM go_image_derivative
+		WHERE image_uuid = $1 AND size = $2 ORDER BY mode LIMIT 1`
+
+	d, err := scanDerivative(r.dbpool.QueryRow(ctx, query, imageID, size))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrDerivativeNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dbpool.QueryRow failed: %w", err)
+	}
+
+	// Record the duration of the select query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return d, nil
+}
+
+// List returns all derivatives of the image, smallest first.
+func (r *pgDerivativeRepository) List(ctx context.Context, imageID string) ([]*Derivative, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL SELECT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := `SELECT ` + derivativeColumns + ` FROM go_image_derivative WHERE image_uuid = $1 ORDER BY size, mode`
+
+	rows, err := r.dbpool.Query(ctx, query, imageID)
+	if err != nil {
+		return nil, fmt.Errorf("dbpool.Query failed: %w", err)
+	}
+
+	derivatives, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Derivative, error) {
+		return scanDerivative(row)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pgx.CollectRows failed: %w", err)
+	}
+
+	// Record the duration of the select query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return derivatives, nil
+}
+
+// Delete removes all derivatives of the image.
+func (r *pgDerivativeRepository) Delete(ctx context.Context, imageID string) error {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL DELETE")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	_, err := r.dbpool.Exec(ctx, `DELETE FROM go_image_derivative WHERE image_uuid = $1`, imageID)
+	if err != nil {
+		return fmt.Errorf("dbpool.Exec failed: %w", err)
+	}
+
+	// Record the duration of the delete query.
+	r.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return nil
+}
+
+// scanDerivative reads a single go_image_derivative row selected with derivativeColumns.
+func scanDerivative(row pgx.Row) (*Derivative, error) {
+	var d Derivative
+
+	err := row.Scan(&d.ImageUUID, &d.Size, &d.Mode, &d.ObjectKey,
+		&d.Width, &d.Height, &d.FileSize, &d.ContentType, &d.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
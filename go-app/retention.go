@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sweepLockID is the Postgres advisory lock key that lets only one replica
+// apply the retention rules at a time.
+const sweepLockID int64 = 0x676f5f7377656570
+
+// purgeLockID is the Postgres advisory lock key that lets only one replica
+// purge deleted images at a time.
+const purgeLockID int64 = 0x676f5f7075726765
+
+// retentionRule selects the images to expire.
+type retentionRule struct {
+	// Name labels the metrics and log lines.
+	Name string
+
+	// Filter selects the candidate images, newest first.
+	Filter ImageFilter
+
+	// Keep is the number of newest matching images that survive.
+	Keep int
+}
+
+// sweepResult counts the images a sweep deleted, or would delete in a dry run.
+type sweepResult struct {
+	// Deleted is the number of deleted images.
+	Deleted int
+
+	// Bytes is the size of the deleted images and their derivatives.
+	Bytes int64
+}
+
+// retentionRules builds the configured rules relative to now.
+func (h *handler) retentionRules(now time.Time) []retentionRule {
+	cfg := h.config.Retention
+
+	var rules []retentionRule
+	if cfg.ErrorMaxAgeHours > 0 {
+		before := now.Add(-time.Duration(cfg.ErrorMaxAgeHours) * time.Hour)
+		rules = append(rules, retentionRule{Name: "error_age", Filter: ImageFilter{Status: StatusError, ProcessedTo: &before}})
+	}
+	if cfg.MaxAgeDays > 0 {
+		before := now.AddDate(0, 0, -cfg.MaxAgeDays)
+		rules = append(rules, retentionRule{Name: "max_age", Filter: ImageFilter{LastModifiedTo: &before}})
+	}
+
+	// Apply the per-tag limits in a stable order.
+	tags := make([]string, 0, len(cfg.KeepLastPerTag))
+	for tag := range cfg.KeepLastPerTag {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		rules = append(rules, retentionRule{Name: "keep_last", Filter: ImageFilter{Tag: tag}, Keep: cfg.KeepLastPerTag[tag]})
+	}
+
+	return rules
+}
+
+// purgeRule selects the deleted images past their grace period relative to now.
+func (h *handler) purgeRule(now time.Time) retentionRule {
+	before := now.Add(-time.Duration(h.config.Retention.DeletedGraceHours) * time.Hour)
+	return retentionRule{Name: "deleted", Filter: ImageFilter{Deleted: true, DeletedTo: &before}}
+}
+
+// sweep applies the retention rules. With dryRun it only logs what it would delete.
+func (h *handler) sweep(ctx context.Context, dryRun bool) (*sweepResult, error) {
+	// Create a new ROOT span to record and trace the sweep.
+	ctx, span := tracer.Start(ctx, "JOB sweep images")
+	defer span.End()
+
+	// Record metrics for this operation
+	start := time.Now()
+	defer func() {
+		h.metrics.duration.With(prometheus.Labels{"op": "sweep"}).Observe(time.Since(start).Seconds())
+	}()
+
+	return h.expire(ctx, h.retentionRules(start), dryRun)
+}
+
+// purge removes the images that were deleted longer than the grace period
+// ago. It is separate from the sweep since deleting an image was already
+// asked for, so the retention dry run does not apply to it.
+func (h *handler) purge(ctx context.Context, dryRun bool) (*sweepResult, error) {
+	// Create a new ROOT span to record and trace the purge.
+	ctx, span := tracer.Start(ctx, "JOB purge images")
+	defer span.End()
+
+	// Record metrics for this operation
+	start := time.Now()
+	defer func() {
+		h.metrics.duration.With(prometheus.Labels{"op": "purge"}).Observe(time.Since(start).Seconds())
+	}()
+
+	return h.expire(ctx, []retentionRule{h.purgeRule(start)}, dryRun)
+}
+
+// expire deletes the images selected by the rules together with their objects
+// and derivatives in batches. With dryRun it only logs what it would delete.
+func (h *handler) expire(ctx context.Context, rules []retentionRule, dryRun bool) (*sweepResult, error) {
+	batch := h.config.Retention.BatchSize
+	res := &sweepResult{}
+
+	// An image matched by several rules is only deleted, or counted, once.
+	done := make(map[string]bool)
+
+	for _, rule := range rules {
+		// Deleted images drop out of the listing, so only skipped ones move the offset.
+		offset := rule.Keep
+		for {
+			f := rule.Filter
+			f.Limit, f.Offset = batch, offset
+
+			page, err := h.images.List(ctx, f)
+			if err != nil {
+				return res, fmt.Errorf("images.List failed: %w", err)
+			}
+
+			for _, image := range page {
+				// Images being processed are left alone until their worker is done.
+				if done[image.ImageUUID] || image.Status == StatusProcessing {
+					offset++
+					continue
+				}
+
+				size, err := h.storedBytes(ctx, image)
+				if err != nil {
+					log.Printf("storedBytes failed: %v", err)
+					offset++
+					continue
+				}
+
+				// Another image ingested from the same key keeps the object.
+				shared, err := h.objectShared(ctx, image)
+				if err != nil {
+					log.Printf("objectShared failed: %v", err)
+					offset++
+					continue
+				}
+				if shared {
+					size -= image.FileSize
+				}
+
+				if dryRun {
+					log.Printf("retention %s: would delete image %s (%s, %d bytes)", rule.Name, image.ImageUUID, image.FileName, size)
+					offset++
+				} else {
+					if err := h.purgeImage(ctx, image, !shared); err != nil {
+						log.Printf("purgeImage failed: %v", err)
+						offset++
+						continue
+					}
+					log.Printf("retention %s: deleted image %s (%s, %d bytes)", rule.Name, image.ImageUUID, image.FileName, size)
+					h.metrics.retentionDeleted.With(prometheus.Labels{"rule": rule.Name}).Inc()
+					h.metrics.retentionBytes.With(prometheus.Labels{"rule": rule.Name}).Add(float64(size))
+				}
+
+				done[image.ImageUUID] = true
+				res.Deleted++
+				res.Bytes += size
+			}
+
+			if len(page) < batch {
+				break
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// storedBytes returns the size of the image and its derivatives in the object store.
+func (h *handler) storedBytes(ctx context.Context, image *Image) (int64, error) {
+	derivatives, err := h.derivatives.List(ctx, image.ImageUUID)
+	if err != nil {
+		return 0, fmt.Errorf("derivatives.List failed: %w", err)
+	}
+
+	size := image.FileSize
+	for _, d := range derivatives {
+		size += d.FileSize
+	}
+
+	return size, nil
+}
+
+// objectShared reports whether another image, live or deleted, uses the
+// object of the image. The object is only removed with the last of them.
+func (h *handler) objectShared(ctx context.Context, image *Image) (bool, error) {
+	var rows int64
+	for _, deleted := range []bool{false, true} {
+		n, err := h.images.Count(ctx, ImageFilter{ObjectKey: image.ObjectKey, Deleted: deleted})
+		if err != nil {
+			return false, fmt.Errorf("images.Count failed: %w", err)
+		}
+		rows += n
+	}
+
+	// The image itself is one of the rows.
+	return rows > 1, nil
+}
+
+// sweepLoop periodically applies the retention rules until the context is done.
+func (h *handler) sweepLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(h.config.Retention.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		ran, err := h.withTryLock(ctx, sweepLockID, func() error {
+			res, err := h.sweep(ctx, h.config.Retention.DryRun)
+			if err == nil && res.Deleted > 0 {
+				log.Printf("retention: %d images, %d bytes (dry run: %t)", res.Deleted, res.Bytes, h.config.Retention.DryRun)
+			}
+			return err
+		})
+		if err != nil {
+			log.Printf("sweep failed: %v", err)
+		} else if !ran {
+			log.Printf("sweep skipped: another replica is sweeping")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeLoop periodically purges the deleted images past their grace period
+// until the context is done.
+func (h *handler) purgeLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(h.config.Retention.PurgeIntervalSeconds) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		ran, err := h.withTryLock(ctx, purgeLockID, func() error {
+			res, err := h.purge(ctx, false)
+			if err == nil && res.Deleted > 0 {
+				log.Printf("purge: %d deleted images, %d bytes", res.Deleted, res.Bytes)
+			}
+			return err
+		})
+		if err != nil {
+			log.Printf("purge failed: %v", err)
+		} else if !ran {
+			log.Printf("purge skipped: another replica is purging")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runSweep handles the "sweep [-dry-run]" subcommand, a single pass of the
+// retention rules from the config followed by a purge of the deleted images.
+func runSweep(c *Config, args []string) {
+	flags := flag.NewFlagSet("sweep", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", c.Retention.DryRun, "Only report the images that would be deleted")
+	flags.Parse(args)
+
+	h := newCommandHandler(c)
+
+	ctx := context.Background()
+
+	var res *sweepResult
+	ran, err := h.withTryLock(ctx, sweepLockID, func() error {
+		var err error
+		res, err = h.sweep(ctx, *dryRun)
+		return err
+	})
+	if err != nil {
+		log.Fatalf("sweep failed: %v", err)
+	}
+	if !ran {
+		log.Fatalf("another replica is sweeping, try again later")
+	}
+
+	var purged *sweepResult
+	ran, err = h.withTryLock(ctx, purgeLockID, func() error {
+		var err error
+		purged, err = h.purge(ctx, *dryRun)
+		return err
+	})
+	if err != nil {
+		log.Fatalf("purge failed: %v", err)
+	}
+	if !ran {
+		log.Fatalf("another replica is purging, try again later")
+	}
+
+	if *dryRun {
+		log.Printf("dry run: %d images would be deleted and %d deleted images purged, %d bytes reclaimed",
+			res.Deleted, purged.Deleted, res.Bytes+purged.Bytes)
+		return
+	}
+	log.Printf("deleted %d images, purged %d deleted images, %d bytes reclaimed",
+		res.Deleted, purged.Deleted, res.Bytes+purged.Bytes)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxTagFacets caps the tag facet to the most common tags of the hits.
+const maxTagFacets = 20
+
+// SearchResult is a page of search hits with facet counts over all hits.
+type SearchResult struct {
+	// Items is the requested page of hits, best matches first.
+	Items []*Image
+
+	// Total is the number of hits.
+	Total int64
+
+	// Facets counts all hits by status, content type, tag and size.
+	Facets SearchFacets
+}
+
+// FacetCount is the number of hits sharing a value.
+type FacetCount struct {
+	// Value is the facet value, e.g. a status or a tag.
+	Value string `json:"value"`
+
+	// Count is the number of hits with the value.
+	Count int64 `json:"count"`
+}
+
+// SearchFacets holds the facet counts of a search, most common values first.
+type SearchFacets struct {
+	Status      []FacetCount `json:"status"`
+	ContentType []FacetCount `json:"contentType"`
+	Tag         []FacetCount `json:"tag"`
+	Size        []FacetCount `json:"size"`
+}
+
+// add appends a count to the named facet.
+func (f *SearchFacets) add(facet, value string, count int64) {
+	fc := FacetCount{Value: value, Count: count}
+	switch facet {
+	case "status":
+		f.Status = append(f.Status, fc)
+	case "contentType":
+		f.ContentType = append(f.ContentType, fc)
+	case "tag":
+		f.Tag = append(f.Tag, fc)
+	case "size":
+		f.Size = append(f.Size, fc)
+	}
+}
+
+// countFacet returns the counts of the values, most common first, keeping at most limit values when limit > 0.
+func countFacet(counts map[string]int64, limit int) []FacetCount {
+	facet := make([]FacetCount, 0, len(counts))
+	for value, n := range counts {
+		facet = append(facet, FacetCount{Value: value, Count: n})
+	}
+	sort.Slice(facet, func(i, j int) bool {
+		if facet[i].Count != facet[j].Count {
+			return facet[i].Count > facet[j].Count
+		}
+		return facet[i].Value < facet[j].Value
+	})
+
+	if limit > 0 && len(facet) > limit {
+		facet = facet[:limit]
+	}
+
+	return facet
+}
+
+// searchImages responds with the images whose file name or tags match the
+// query q, narrowed by the list filters, and the facet counts of all hits.
+func (h *handler) searchImages(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP GET /api/images/search")
+	defer span.End()
+
+	limit, offset, err := pagination(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	f := ImageFilter{
+		Status:      c.Query("status"),
+		ContentType: c.Query("content_type"),
+		Tag:         c.Query("tag"),
+		Size:        c.Query("size"),
+		Limit:       limit,
+		Offset:      offset,
+	}
+	if f.Size != "" && f.Size != "small" && f.Size != "large" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid size: expected small or large"})
+		return
+	}
+
+	res, err := h.images.Search(ctx, strings.TrimSpace(c.Query("q")), f)
+	if err != nil {
+		log.Printf("images.Search failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	// Render empty facets as empty lists rather than null.
+	for _, facet := range []*[]FacetCount{&res.Facets.Status, &res.Facets.ContentType, &res.Facets.Tag, &res.Facets.Size} {
+		if *facet == nil {
+			*facet = []FacetCount{}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":  res.Items,
+		"total":  res.Total,
+		"limit":  limit,
+		"offset": offset,
+		"facets": res.Facets,
+	})
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// semver is a parsed semantic version. Build metadata is dropped because it
+// does not take part in precedence.
+type semver struct {
+	major, minor, patch uint64
+
+	// pre holds the dot-separated pre-release identifiers, e.g. rc and 1 for 3.0.0-rc.1.
+	pre []string
+}
+
+// parseSemver parses a version such as 3.0.0, v2.1.5 or 3.0.0-rc.1+build.7.
+// Devices often report short versions, so missing minor and patch numbers
+// count as zero: 3 and 3.0 both equal 3.0.0.
+func parseSemver(s string) (semver, bool) {
+	var v semver
+
+	s = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(s), "v"), "V")
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		v.pre = strings.Split(s[i+1:], ".")
+		for _, id := range v.pre {
+			if id == "" || strings.Trim(id, "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz-") != "" {
+				return semver{}, false
+			}
+		}
+		s = s[:i]
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return semver{}, false
+	}
+	numbers := []*uint64{&v.major, &v.minor, &v.patch}
+	for i, p := range parts {
+		n, err := strconv.ParseUint(p, 10, 64)
+		if err != nil {
+			return semver{}, false
+		}
+		*numbers[i] = n
+	}
+
+	return v, true
+}
+
+// compare returns -1, 0 or +1 when v has lower, equal or higher precedence than o.
+func (v semver) compare(o semver) int {
+	for _, p := range [][2]uint64{{v.major, o.major}, {v.minor, o.minor}, {v.patch, o.patch}} {
+		if p[0] != p[1] {
+			return cmpInt(p[0] < p[1])
+		}
+	}
+
+	// A pre-release sorts before the release itself.
+	switch {
+	case len(v.pre) == 0 && len(o.pre) == 0:
+		return 0
+	case len(v.pre) == 0:
+		return 1
+	case len(o.pre) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.pre) && i < len(o.pre); i++ {
+		a, b := v.pre[i], o.pre[i]
+		if a == b {
+			continue
+		}
+
+		// Numeric identifiers compare as numbers and sort before alphanumeric ones.
+		an, aErr := strconv.ParseUint(a, 10, 64)
+		bn, bErr := strconv.ParseUint(b, 10, 64)
+		switch {
+		case aErr == nil && bErr == nil:
+			return cmpInt(an < bn)
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			return cmpInt(a < b)
+		}
+	}
+
+	// A larger set of pre-release fields has higher precedence.
+	if len(v.pre) == len(o.pre) {
+		return 0
+	}
+	return cmpInt(len(v.pre) < len(o.pre))
+}
+
+// cmpInt returns -1 if less is true and +1 otherwise.
+func cmpInt(less bool) int {
+	if less {
+		return -1
+	}
+	return 1
+}
+
+// versionConstraint compares firmware versions with an operator, e.g. <3.0.0.
+type versionConstraint struct {
+	// op is one of <, <=, >, >=, = and !=.
+	op string
+
+	// version is the version to compare with.
+	version semver
+}
+
+// versionOperators lists the operators, longest first so that >= is not read as >.
+var versionOperators = []string{"<=", ">=", "!=", "==", "<", ">", "="}
+
+// parseVersionConstraint parses an operator followed by a version, e.g. >=2.1.5.
+func parseVersionConstraint(s string) (versionConstraint, bool) {
+	for _, op := range versionOperators {
+		if !strings.HasPrefix(s, op) {
+			continue
+		}
+
+		v, ok := parseSemver(s[len(op):])
+		if !ok {
+			return versionConstraint{}, false
+		}
+		if op == "==" {
+			op = "="
+		}
+		return versionConstraint{op: op, version: v}, true
+	}
+
+	return versionConstraint{}, false
+}
+
+// matches reports whether the firmware satisfies the constraint. Firmware
+// that is not a semantic version never matches.
+func (c versionConstraint) matches(firmware string) bool {
+	v, ok := parseSemver(firmware)
+	if !ok {
+		return false
+	}
+
+	n := v.compare(c.version)
+	switch c.op {
+	case "<":
+		return n < 0
+	case "<=":
+		return n <= 0
+	case ">":
+		return n > 0
+	case ">=":
+		return n >= 0
+	case "!=":
+		return n != 0
+	default:
+		return n == 0
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import "testing"
+
+func TestSemverCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"3.0.0", "3.0.0", 0},
+		{"3", "3.0.0", 0},
+		{"3.1", "3.1.0", 0},
+		{"v3.0.0", "3.0.0", 0},
+		{"V3.0.0", "3.0.0", 0},
+		{"3.0.0+build.7", "3.0.0", 0},
+		{"2.9.9", "3.0.0", -1},
+		{"3.0.10", "3.0.9", 1},
+		{"3.0.0-rc.1", "3.0.0", -1},
+		{"3.0.0-rc.2", "3.0.0-rc.10", -1},
+		{"3.0.0-rc.1", "3.0.0-rc.1", 0},
+		{"3.0.0-1", "3.0.0-alpha", -1},
+		{"3.0.0-alpha", "3.0.0-beta", -1},
+		{"3.0.0-alpha", "3.0.0-alpha.1", -1},
+		{"3.0.0-alpha.beta", "3.0.0-alpha.1", 1},
+		{"3.0.0-rc.1+build.7", "3.0.0-rc.1", 0},
+	}
+	for _, tt := range tests {
+		a, ok := parseSemver(tt.a)
+		if !ok {
+			t.Fatalf("parseSemver(%q) failed", tt.a)
+		}
+		b, ok := parseSemver(tt.b)
+		if !ok {
+			t.Fatalf("parseSemver(%q) failed", tt.b)
+		}
+		if got := a.compare(b); got != tt.want {
+			t.Errorf("compare(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := b.compare(a); got != -tt.want {
+			t.Errorf("compare(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestParseSemverInvalid(t *testing.T) {
+	for _, s := range []string{"", "v", "3.0.0.1", "3.x", "-1.0.0", "3..0", "3.0.0-", "3.0.0-rc..1", "3.0.0-rc_1", "latest"} {
+		if v, ok := parseSemver(s); ok {
+			t.Errorf("parseSemver(%q) = %+v, want false", s, v)
+		}
+	}
+}
+
+func TestVersionConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		firmware   string
+		want       bool
+	}{
+		{">=3.0.0", "3.0.0", true},
+		{">=3.0.0", "2.9.9", false},
+		{">3.0.0", "3.0.0", false},
+		{">3.0.0", "3.0.1", true},
+		{"<=3.0.0", "3.0.0", true},
+		{"<3.0.0", "3.0.0-rc.1", true},
+		{"<3.0.0", "3.0.0", false},
+		{"=3.0.0", "v3", true},
+		{"==3.0.0", "3.0", true},
+		{"!=3.0.0", "3.0.1", true},
+		{"!=3.0.0", "3.0.0", false},
+		{"<3.0.0", "unknown", false},
+		{"!=3.0.0", "", false},
+	}
+	for _, tt := range tests {
+		c, ok := parseVersionConstraint(tt.constraint)
+		if !ok {
+			t.Fatalf("parseVersionConstraint(%q) failed", tt.constraint)
+		}
+		if got := c.matches(tt.firmware); got != tt.want {
+			t.Errorf("%q matches %q = %t, want %t", tt.constraint, tt.firmware, got, tt.want)
+		}
+	}
+}
+
+func TestParseVersionConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		wantOp     string
+		wantOK     bool
+	}{
+		{">=2.1.5", ">=", true},
+		{"<=2.1.5", "<=", true},
+		{">2.1.5", ">", true},
+		{"<2.1.5", "<", true},
+		{"!=2.1.5", "!=", true},
+		{"==2.1.5", "=", true},
+		{"=2.1.5", "=", true},
+		{"2.1.5", "", false},
+		{"~2.1.5", "", false},
+		{">=", "", false},
+		{">=x", "", false},
+		{"=>2.1.5", "", false},
+	}
+	for _, tt := range tests {
+		c, ok := parseVersionConstraint(tt.constraint)
+		if ok != tt.wantOK || c.op != tt.wantOp {
+			t.Errorf("parseVersionConstraint(%q) = %q, %t, want %q, %t", tt.constraint, c.op, ok, tt.wantOp, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// Image processing statuses.
+const (
+	// StatusUploaded means the image is stored and waiting to be processed.
+	StatusUploaded = "uploaded"
+
+	// StatusProcessing means a worker is processing the image.
+	StatusProcessing = "processing"
+
+	// StatusProcessed means the image was decoded and its derivatives generated.
+	StatusProcessed = "processed"
+
+	// StatusError means the image could not be processed; see the status reason.
+	StatusError = "error"
+
+	// StatusMissing means the reconciler no longer found the object in the bucket.
+	StatusMissing = "missing"
+)
+
+// ErrInvalidTransition is returned when an image cannot move to the requested status.
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+// transitions lists the statuses each status may move to. Processed and failed
+// images go back to uploaded when they are reprocessed. Any image can go missing
+// when its object disappears, and comes back as uploaded when the object does.
+var transitions = map[string][]string{
+	StatusUploaded:   {StatusProcessing, StatusError, StatusMissing},
+	StatusProcessing: {StatusProcessed, StatusError, StatusUploaded, StatusMissing},
+	StatusProcessed:  {StatusUploaded, StatusMissing},
+	StatusError:      {StatusUploaded, StatusMissing},
+	StatusMissing:    {StatusUploaded},
+}
+
+// canTransition reports whether an image may move from one status to another.
+func canTransition(from, to string) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ImageEvent records a status transition of an image.
+type ImageEvent struct {
+	// ImageUUID is the ID of the image.
+	ImageUUID string `json:"imageUuid"`
+
+	// FromStatus is the status before the transition; empty when the image was created.
+	FromStatus string `json:"fromStatus"`
+
+	// ToStatus is the status after the transition.
+	ToStatus string `json:"toStatus"`
+
+	// Reason explains the transition.
+	Reason string `json:"reason"`
+
+	// CreatedAt is when the transition happened.
+	CreatedAt time.Time `json:"createdAt"`
+}
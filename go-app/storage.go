@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ErrObjectNotFound is returned when the object store has no object under the key.
+var ErrObjectNotFound = errors.New("object not found")
+
+// managedObject reports whether the app writes the object under the key
+// itself, like derivatives and firmware artifacts, rather than it being an
+// image upload.
+func managedObject(key string) bool {
+	return strings.HasPrefix(key, derivativePrefix) || strings.HasPrefix(key, firmwarePrefix)
+}
+
+// ObjectInfo describes an object in the object store.
+type ObjectInfo struct {
+	// Key is the unique key of the object.
+	Key string
+
+	// Size is the size of the object in bytes.
+	Size int64
+
+	// ContentType is the MIME type of the object.
+	ContentType string
+
+	// LastModified is the timestamp when the object was last modified.
+	LastModified time.Time
+
+	// ETag is the entity tag of the object, if the backend provides one.
+	ETag string
+}
+
+// Object is an object opened for reading; the caller must close the Body.
+type Object struct {
+	ObjectInfo
+
+	// Body streams the object content.
+	Body io.ReadCloser
+}
+
+// ObjectStore stores image bytes under string keys.
+type ObjectStore interface {
+	// Get opens the object for reading.
+	Get(ctx context.Context, key string) (*Object, error)
+
+	// GetRange opens length bytes of the object starting at offset. The
+	// returned info still describes the whole object.
+	GetRange(ctx context.Context, key string, offset, length int64) (*Object, error)
+
+	// Put writes the body under the key, replacing any existing object.
+	Put(ctx context.Context, key string, body io.Reader, contentType string) error
+
+	// Head returns the object metadata without its content.
+	Head(ctx context.Context, key string) (*ObjectInfo, error)
+
+	// Delete removes the object; deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+
+	// List calls fn for every object whose key starts with prefix, in key order.
+	List(ctx context.Context, prefix string, fn func(*ObjectInfo) error) error
+}
+
+// Presigner is implemented by object stores that can hand out time-limited
+// URLs, so clients transfer bytes to and from the bucket directly.
+type Presigner interface {
+	// PresignPut returns a URL to upload the object with the given content type.
+	PresignPut(ctx context.Context, key string, contentType string, ttl time.Duration) (string, error)
+
+	// PresignGet returns a URL to download the object.
+	PresignGet(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
+
+// newObjectStore creates the object store selected in the config.
+func newObjectStore(c *Config, m *metrics) (ObjectStore, error) {
+	switch c.Storage.Backend {
+	case "", "s3":
+		return newS3Store(&c.S3Config, m), nil
+	case "fs":
+		return newFSStore(c.Storage.Dir)
+	case "memory":
+		return newMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", c.Storage.Backend)
+	}
+}
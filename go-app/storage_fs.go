@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// fsStore keeps objects as files in a local directory, handy for development.
+type fsStore struct {
+	// Root directory for all objects.
+	root string
+}
+
+// newFSStore creates the root directory if needed and returns the store.
+func newFSStore(dir string) (*fsStore, error) {
+	if dir == "" {
+		return nil, errors.New("storage dir is required for the fs backend")
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("os.MkdirAll failed: %w", err)
+	}
+
+	return &fsStore{root: dir}, nil
+}
+
+// path maps an object key to a file path, rejecting keys that escape the root.
+func (s *fsStore) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if key == "" || clean == "/" || strings.HasSuffix(key, "/") {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+
+	return filepath.Join(s.root, filepath.FromSlash(clean)), nil
+}
+
+// info builds the object metadata from the file.
+func (s *fsStore) info(key string, fi fs.FileInfo) *ObjectInfo {
+	// Files carry no content type, so guess it from the extension.
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	return &ObjectInfo{
+		Key:          key,
+		Size:         fi.Size(),
+		ContentType:  contentType,
+		LastModified: fi.ModTime(),
+	}
+}
+
+// Get opens the file for reading.
+func (s *fsStore) Get(ctx context.Context, key string) (*Object, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("os.Open failed: %w", err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("f.Stat failed: %w", err)
+	}
+
+	return &Object{ObjectInfo: *s.info(key, fi), Body: f}, nil
+}
+
+// GetRange opens the file and seeks to the start of the range.
+func (s *fsStore) GetRange(ctx context.Context, key string, offset, length int64) (*Object, error) {
+	obj, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	f := obj.Body.(*os.File)
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("f.Seek failed: %w", err)
+	}
+
+	// Stop reading at the end of the range but close the file itself.
+	obj.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}
+
+	return obj, nil
+}
+
+// Put writes the body to a temporary file and renames it into place.
+func (s *fsStore) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return fmt.Errorf("os.MkdirAll failed: %w", err)
+	}
+
+	// Write into a temporary file so readers never see a partial object.
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("os.CreateTemp failed: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("io.Copy failed: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("tmp.Close failed: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return fmt.Errorf("os.Rename failed: %w", err)
+	}
+
+	return nil
+}
+
+// Head returns the file metadata.
+func (s *fsStore) Head(ctx context.Context, key string) (*ObjectInfo, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("os.Stat failed: %w", err)
+	}
+
+	return s.info(key, fi), nil
+}
+
+// Delete removes the file.
+func (s *fsStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(p)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("os.Remove failed: %w", err)
+	}
+
+	return nil
+}
+
+// List walks the root directory and reports every file under the prefix.
+func (s *fsStore) List(ctx context.Context, prefix string, fn func(*ObjectInfo) error) error {
+	return filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Skip directories and files that are still being written.
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		return fn(s.info(key, fi))
+	})
+}
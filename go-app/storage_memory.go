@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryObject is an object held by memoryStore.
+type memoryObject struct {
+	info ObjectInfo
+	data []byte
+}
+
+// memoryStore keeps objects in memory; it is meant for tests and demos.
+type memoryStore struct {
+	mu      sync.RWMutex
+	objects map[string]*memoryObject
+}
+
+// newMemoryStore returns an empty in-memory store.
+func newMemoryStore() *memoryStore {
+	return &memoryStore{objects: make(map[string]*memoryObject)}
+}
+
+// Get returns a reader over the object data.
+func (s *memoryStore) Get(ctx context.Context, key string) (*Object, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	o, ok := s.objects[key]
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+
+	// Objects are replaced, never mutated, so the slice can be shared.
+	return &Object{ObjectInfo: o.info, Body: io.NopCloser(bytes.NewReader(o.data))}, nil
+}
+
+// GetRange returns a reader over a slice of the object data.
+func (s *memoryStore) GetRange(ctx context.Context, key string, offset, length int64) (*Object, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	o, ok := s.objects[key]
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+
+	// Clamp the range to the data so a stale size cannot cause a panic.
+	size := int64(len(o.data))
+	start, end := min(offset, size), min(offset+length, size)
+
+	return &Object{ObjectInfo: o.info, Body: io.NopCloser(bytes.NewReader(o.data[start:end]))}, nil
+}
+
+// Put reads the whole body into memory.
+func (s *memoryStore) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("io.ReadAll failed: %w", err)
+	}
+
+	sum := md5.Sum(data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.objects[key] = &memoryObject{
+		info: ObjectInfo{
+			Key:          key,
+			Size:         int64(len(data)),
+			ContentType:  contentType,
+			LastModified: time.Now(),
+			ETag:         hex.EncodeToString(sum[:]),
+		},
+		data: data,
+	}
+
+	return nil
+}
+
+// Head returns the object metadata.
+func (s *memoryStore) Head(ctx context.Context, key string) (*ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	o, ok := s.objects[key]
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+
+	info := o.info
+	return &info, nil
+}
+
+// Delete removes the object.
+func (s *memoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.objects, key)
+	return nil
+}
+
+// List reports the objects under the prefix in key order.
+func (s *memoryStore) List(ctx context.Context, prefix string, fn func(*ObjectInfo) error) error {
+	// Snapshot the matching objects so fn may call back into the store.
+	s.mu.RLock()
+	var infos []ObjectInfo
+	for key, o := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			infos = append(infos, o.info)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+
+	for i := range infos {
+		if err := fn(&infos[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// s3Store keeps objects in an S3 (or Minio) bucket.
+type s3Store struct {
+	// S3 seesion, should be shared
+	sess *session.Session
+
+	// S3 client created from the session.
+	svc *s3.S3
+
+	// Bucket to store objects.
+	bucket string
+
+	// Prometheus metrics
+	metrics *metrics
+}
+
+// newS3Store initializes the S3 session and returns the store.
+func newS3Store(c *S3Config, m *metrics) *s3Store {
+	// Get credentials to authorize with AWS S3 API.
+	crds := credentials.NewStaticCredentials(c.User, c.Secret, "")
+
+	// Create S3 config.
+	s3c := aws.Config{
+		Region:           &c.Region,
+		Endpoint:         &c.Endpoint,
+		S3ForcePathStyle: &c.PathStyle,
+		Credentials:      crds,
+	}
+
+	// Establish a new session with the AWS S3 API.
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+		Config:            s3c,
+	}))
+
+	return &s3Store{sess: sess, svc: s3.New(sess), bucket: c.Bucket, metrics: m}
+}
+
+// Get opens the S3 object for reading.
+func (s *s3Store) Get(ctx context.Context, key string) (*Object, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "S3 GET")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	// Send the request to the S3 object store to download the image.
+	output, err := s.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, s3Error("svc.GetObject", err)
+	}
+
+	// Record the duration of the request to S3.
+	s.metrics.duration.With(prometheus.Labels{"op": "s3"}).Observe(time.Since(now).Seconds())
+
+	obj := &Object{
+		ObjectInfo: ObjectInfo{
+			Key:          key,
+			Size:         aws.Int64Value(output.ContentLength),
+			ContentType:  aws.StringValue(output.ContentType),
+			LastModified: aws.TimeValue(output.LastModified),
+			ETag:         strings.Trim(aws.StringValue(output.ETag), `"`),
+		},
+		Body: output.Body,
+	}
+
+	return obj, nil
+}
+
+// GetRange opens a byte range of the S3 object for reading.
+func (s *s3Store) GetRange(ctx context.Context, key string, offset, length int64) (*Object, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "S3 GET range")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	// Ask S3 for the range only, so the rest of the object is never transferred.
+	output, err := s.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		return nil, s3Error("svc.GetObject", err)
+	}
+
+	// Record the duration of the request to S3.
+	s.metrics.duration.With(prometheus.Labels{"op": "s3"}).Observe(time.Since(now).Seconds())
+
+	// The total size is only available from the Content-Range header.
+	size := aws.Int64Value(output.ContentLength)
+	if cr := aws.StringValue(output.ContentRange); cr != "" {
+		if i := strings.LastIndexByte(cr, '/'); i >= 0 {
+			if total, err := strconv.ParseInt(cr[i+1:], 10, 64); err == nil {
+				size = total
+			}
+		}
+	}
+
+	obj := &Object{
+		ObjectInfo: ObjectInfo{
+			Key:          key,
+			Size:         size,
+			ContentType:  aws.StringValue(output.ContentType),
+			LastModified: aws.TimeValue(output.LastModified),
+			ETag:         strings.Trim(aws.StringValue(output.ETag), `"`),
+		},
+		Body: output.Body,
+	}
+
+	return obj, nil
+}
+
+// Put streams the body into the S3 bucket.
+func (s *s3Store) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "S3 PUT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	// The upload manager splits large bodies into multipart uploads,
+	// so the whole image never has to be held in memory.
+	uploader := s3manager.NewUploaderWithClient(s.svc)
+
+	// Send the image to the S3 object store.
+	_, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+		Body:        body,
+	})
+	if err != nil {
+		return fmt.Errorf("uploader.Upload failed: %w", err)
+	}
+
+	// Record the duration of the request to S3.
+	s.metrics.duration.With(prometheus.Labels{"op": "s3"}).Observe(time.Since(now).Seconds())
+
+	return nil
+}
+
+// Head returns the S3 object metadata.
+func (s *s3Store) Head(ctx context.Context, key string) (*ObjectInfo, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "S3 HEAD")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	output, err := s.svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, s3Error("svc.HeadObject", err)
+	}
+
+	// Record the duration of the request to S3.
+	s.metrics.duration.With(prometheus.Labels{"op": "s3"}).Observe(time.Since(now).Seconds())
+
+	info := &ObjectInfo{
+		Key:          key,
+		Size:         aws.Int64Value(output.ContentLength),
+		ContentType:  aws.StringValue(output.ContentType),
+		LastModified: aws.TimeValue(output.LastModified),
+		ETag:         strings.Trim(aws.StringValue(output.ETag), `"`),
+	}
+
+	return info, nil
+}
+
+// Delete removes the S3 object.
+func (s *s3Store) Delete(ctx context.Context, key string) error {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "S3 DELETE")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	_, err := s.svc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("svc.DeleteObject failed: %w", err)
+	}
+
+	// Record the duration of the request to S3.
+	s.metrics.duration.With(prometheus.Labels{"op": "s3"}).Observe(time.Since(now).Seconds())
+
+	return nil
+}
+
+// List walks all pages of the bucket listing under the prefix.
+func (s *s3Store) List(ctx context.Context, prefix string, fn func(*ObjectInfo) error) error {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "S3 LIST")
+	defer span.End()
+
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}
+
+	// Stop paging as soon as the callback fails.
+	var fnErr error
+	err := s.svc.ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, o := range page.Contents {
+			fnErr = fn(&ObjectInfo{
+				Key:          aws.StringValue(o.Key),
+				Size:         aws.Int64Value(o.Size),
+				LastModified: aws.TimeValue(o.LastModified),
+				ETag:         strings.Trim(aws.StringValue(o.ETag), `"`),
+			})
+			if fnErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("svc.ListObjectsV2 failed: %w", err)
+	}
+
+	return fnErr
+}
+
+// PresignPut signs a PUT request with the store credentials.
+func (s *s3Store) PresignPut(ctx context.Context, key string, contentType string, ttl time.Duration) (string, error) {
+	// Create a new CHILD span to record and trace the request.
+	_, span := tracer.Start(ctx, "S3 PRESIGN PUT")
+	defer span.End()
+
+	// The content type is part of the signature, so the client must send the same header.
+	req, _ := s.svc.PutObjectRequest(&s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	})
+
+	url, err := req.Presign(ttl)
+	if err != nil {
+		return "", fmt.Errorf("req.Presign failed: %w", err)
+	}
+
+	return url, nil
+}
+
+// PresignGet signs a GET request with the store credentials.
+func (s *s3Store) PresignGet(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	// Create a new CHILD span to record and trace the request.
+	_, span := tracer.Start(ctx, "S3 PRESIGN GET")
+	defer span.End()
+
+	req, _ := s.svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+
+	url, err := req.Presign(ttl)
+	if err != nil {
+		return "", fmt.Errorf("req.Presign failed: %w", err)
+	}
+
+	return url, nil
+}
+
+// s3Error wraps an S3 error and maps missing objects to ErrObjectNotFound.
+func s3Error(op string, err error) error {
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotFound {
+		return fmt.Errorf("%s failed: %w", op, ErrObjectNotFound)
+	}
+
+	return fmt.Errorf("%s failed: %w", op, err)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errRangeNotSatisfiable is returned when the requested range lies outside the object.
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// serveObject streams the object from the store to the client without
+// buffering it, honouring a single byte range in the Range header. Store errors
+// are returned before anything is written so the caller can map them.
+func (h *handler) serveObject(ctx context.Context, c *gin.Context, key, contentType string, headers map[string]string) error {
+	// Multiple ranges and malformed headers are answered with the whole object.
+	rangeHeader := c.GetHeader("Range")
+	if rangeHeader == "" {
+		return h.serveWhole(ctx, c, key, contentType, headers)
+	}
+
+	// The object size is needed to resolve open-ended and suffix ranges.
+	info, err := h.store.Head(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	start, length, ok, err := parseRange(rangeHeader, info.Size)
+	if errors.Is(err, errRangeNotSatisfiable) {
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+		c.JSON(http.StatusRequestedRangeNotSatisfiable, gin.H{"message": "range not satisfiable"})
+		return nil
+	}
+	if !ok {
+		return h.serveWhole(ctx, c, key, contentType, headers)
+	}
+
+	obj, err := h.store.GetRange(ctx, key, start, length)
+	if err != nil {
+		return err
+	}
+	defer obj.Body.Close()
+
+	extra := objectHeaders(&obj.ObjectInfo, headers)
+	extra["Content-Range"] = fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, info.Size)
+
+	c.DataFromReader(http.StatusPartialContent, length, contentType, obj.Body, extra)
+	return nil
+}
+
+// serveWhole streams the whole object to the client.
+func (h *handler) serveWhole(ctx context.Context, c *gin.Context, key, contentType string, headers map[string]string) error {
+	obj, err := h.store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer obj.Body.Close()
+
+	c.DataFromReader(http.StatusOK, obj.Size, contentType, obj.Body, objectHeaders(&obj.ObjectInfo, headers))
+	return nil
+}
+
+// objectHeaders returns the caller headers plus the caching and range headers of the object.
+func objectHeaders(info *ObjectInfo, headers map[string]string) map[string]string {
+	extra := map[string]string{"Accept-Ranges": "bytes"}
+	for k, v := range headers {
+		extra[k] = v
+	}
+
+	if info.ETag != "" {
+		extra["ETag"] = strconv.Quote(info.ETag)
+	}
+	if !info.LastModified.IsZero() {
+		extra["Last-Modified"] = info.LastModified.UTC().Format(http.TimeFormat)
+	}
+
+	return extra
+}
+
+// parseRange resolves a single "bytes=" range against the object size. It
+// returns ok=false for headers that should be ignored, which RFC 9110 allows
+// for multiple ranges and requires for malformed ones.
+func parseRange(header string, size int64) (start, length int64, ok bool, err error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, nil
+	}
+
+	// A suffix range such as "bytes=-500" asks for the last bytes of the object.
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false, nil
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, false, errRangeNotSatisfiable
+		}
+		n = min(n, size)
+		return size - n, n, true, nil
+	}
+
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false, nil
+	}
+
+	// An open range such as "bytes=100-" runs to the end of the object.
+	end := size - 1
+	if last != "" {
+		e, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || e < start {
+			return 0, 0, false, nil
+		}
+		end = min(e, size-1)
+	}
+
+	if start >= size {
+		return 0, 0, false, errRangeNotSatisfiable
+	}
+
+	return start, end - start + 1, true, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     string
+		size       int64
+		wantStart  int64
+		wantLength int64
+		wantOK     bool
+		wantErr    error
+	}{
+		{"closed", "bytes=0-9", 100, 0, 10, true, nil},
+		{"end past size", "bytes=90-200", 100, 90, 10, true, nil},
+		{"open ended", "bytes=40-", 100, 40, 60, true, nil},
+		{"suffix", "bytes=-30", 100, 70, 30, true, nil},
+		{"suffix larger than size", "bytes=-500", 100, 0, 100, true, nil},
+		{"spaces", "bytes= 5-6 ", 100, 5, 2, true, nil},
+		{"empty suffix", "bytes=-0", 100, 0, 0, false, errRangeNotSatisfiable},
+		{"suffix of empty object", "bytes=-10", 0, 0, 0, false, errRangeNotSatisfiable},
+		{"start beyond size", "bytes=100-", 100, 0, 0, false, errRangeNotSatisfiable},
+		{"start far beyond size", "bytes=500-600", 100, 0, 0, false, errRangeNotSatisfiable},
+		{"end before start", "bytes=5-2", 100, 0, 0, false, nil},
+		{"multiple ranges", "bytes=0-1,5-6", 100, 0, 0, false, nil},
+		{"other unit", "items=0-1", 100, 0, 0, false, nil},
+		{"no dash", "bytes=5", 100, 0, 0, false, nil},
+		{"negative start", "bytes=--5", 100, 0, 0, false, nil},
+		{"not a number", "bytes=a-b", 100, 0, 0, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, length, ok, err := parseRange(tt.header, tt.size)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("parseRange(%q, %d) error = %v, want %v", tt.header, tt.size, err, tt.wantErr)
+			}
+			if start != tt.wantStart || length != tt.wantLength || ok != tt.wantOK {
+				t.Errorf("parseRange(%q, %d) = %d, %d, %t, want %d, %d, %t",
+					tt.header, tt.size, start, length, ok, tt.wantStart, tt.wantLength, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGetImageRange(t *testing.T) {
+	r, h := newTestRouter(t)
+	image := insertImage(t, h, "a.png")
+	if err := h.store.Put(context.Background(), image.ObjectKey, strings.NewReader("0123456789"), "image/png"); err != nil {
+		t.Fatalf("store.Put failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		header   string
+		wantCode int
+		wantBody string
+	}{
+		{"single range", "bytes=2-4", http.StatusPartialContent, "234"},
+		{"suffix", "bytes=-3", http.StatusPartialContent, "789"},
+		{"multiple ranges", "bytes=0-1,5-6", http.StatusOK, "0123456789"},
+		{"malformed", "bytes=5-2", http.StatusOK, "0123456789"},
+		{"unsatisfiable", "bytes=10-", http.StatusRequestedRangeNotSatisfiable, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/images/"+image.ImageUUID+"?content=true", nil)
+			req.Header.Set("Range", tt.header)
+			w := serve(r, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("got body %q, want %q", w.Body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxTelemetryBody caps the size of a telemetry request body.
+const maxTelemetryBody = 8 << 20
+
+// TelemetrySample is a single numeric reading of a device.
+type TelemetrySample struct {
+	// DeviceUUID is the device that took the reading.
+	DeviceUUID string `json:"deviceUuid"`
+
+	// Metric is the name of the reading, e.g. temperature.
+	Metric string `json:"metric"`
+
+	// Time is when the reading was taken.
+	Time time.Time `json:"time"`
+
+	// Value is the reading.
+	Value float64 `json:"value"`
+}
+
+// TelemetryBucket aggregates the samples of a metric within one time step.
+type TelemetryBucket struct {
+	// Time is the start of the bucket.
+	Time time.Time `json:"time"`
+
+	// Avg is the mean of the samples.
+	Avg float64 `json:"avg"`
+
+	// Min is the lowest sample.
+	Min float64 `json:"min"`
+
+	// Max is the highest sample.
+	Max float64 `json:"max"`
+
+	// Count is the number of samples.
+	Count int64 `json:"count"`
+}
+
+// TelemetryQuery selects the samples of a device to aggregate.
+type TelemetryQuery struct {
+	// DeviceUUID is the device to query.
+	DeviceUUID string
+
+	// Metric limits the query to a single metric when set.
+	Metric string
+
+	// From is the inclusive start of the range.
+	From time.Time
+
+	// To is the exclusive end of the range.
+	To time.Time
+
+	// Step is the width of the buckets, in whole seconds.
+	Step time.Duration
+}
+
+// TelemetryStore stores device telemetry samples.
+type TelemetryStore interface {
+	// Insert saves the samples. Samples already stored for the same device,
+	// metric and time are skipped, so devices may safely resend a batch. It
+	// returns how many samples were new.
+	Insert(ctx context.Context, samples []TelemetrySample) (int64, error)
+
+	// Query aggregates the samples of the device into buckets of Step aligned
+	// to the Unix epoch. It returns the buckets by metric, oldest first;
+	// buckets without samples are left out.
+	Query(ctx context.Context, q TelemetryQuery) (map[string][]TelemetryBucket, error)
+}
+
+// parseTelemetryJSON parses a batch keyed by device UUID, where every point
+// carries an optional RFC 3339 time and one numeric field per metric:
+//
+//	{"<uuid>": [{"time": "2024-05-01T10:00:00Z", "temperature": 21.5, "battery": 87}]}
+//
+// Points without a time are stamped with now.
+func parseTelemetryJSON(body []byte, now time.Time) ([]TelemetrySample, error) {
+	var batch map[string][]map[string]json.RawMessage
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return nil, fmt.Errorf("invalid request body")
+	}
+
+	var samples []TelemetrySample
+	for device, points := range batch {
+		id, err := uuid.Parse(device)
+		if err != nil {
+			return nil, fmt.Errorf("invalid device uuid %q", device)
+		}
+
+		for _, point := range points {
+			t := now
+			if raw, ok := point["time"]; ok {
+				if err := json.Unmarshal(raw, &t); err != nil {
+					return nil, fmt.Errorf("device %s: invalid time: expected RFC 3339 timestamp", id)
+				}
+			}
+			t, ok := telemetryTime(t)
+			if !ok {
+				return nil, fmt.Errorf("device %s: time out of range", id)
+			}
+
+			for metric, raw := range point {
+				if metric == "time" {
+					continue
+				}
+				var value float64
+				if err := json.Unmarshal(raw, &value); err != nil {
+					return nil, fmt.Errorf("device %s: %s is not a number", id, metric)
+				}
+				samples = append(samples, TelemetrySample{DeviceUUID: id.String(), Metric: metric, Time: t, Value: value})
+			}
+		}
+	}
+
+	return samples, nil
+}
+
+// linePrecisions maps the precision query parameter of line protocol
+// payloads to the unit of the timestamps.
+var linePrecisions = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+}
+
+// parseLineProtocol parses InfluxDB line protocol with the device UUID in the
+// device tag, one numeric field per metric and an optional timestamp in the
+// precision unit:
+//
+//	telemetry,device=<uuid> temperature=21.5,battery=87i 1714557600000000000
+//
+// The measurement name and other tags are ignored. Escaped spaces and commas
+// are not supported since neither UUIDs nor numbers contain them.
+func parseLineProtocol(body []byte, precision time.Duration, now time.Time) ([]TelemetrySample, error) {
+	var samples []TelemetrySample
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), maxTelemetryBody)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.Fields(line)
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("line %d: expected measurement,tags fields [timestamp]", n)
+		}
+
+		var device string
+		for _, tag := range strings.Split(parts[0], ",")[1:] {
+			if k, v, _ := strings.Cut(tag, "="); k == "device" {
+				device = v
+			}
+		}
+		id, err := uuid.Parse(device)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: missing or invalid device tag", n)
+		}
+
+		t := now
+		if len(parts) == 3 {
+			ts, err := strconv.ParseInt(parts[2], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid timestamp", n)
+			}
+			// Nanoseconds since the epoch must fit in an int64.
+			if ts > math.MaxInt64/int64(precision) || ts < math.MinInt64/int64(precision) {
+				return nil, fmt.Errorf("line %d: timestamp out of range", n)
+			}
+			t = time.Unix(0, ts*int64(precision))
+		}
+		t, ok := telemetryTime(t)
+		if !ok {
+			return nil, fmt.Errorf("line %d: timestamp out of range", n)
+		}
+
+		for _, field := range strings.Split(parts[1], ",") {
+			metric, raw, ok := strings.Cut(field, "=")
+			if !ok || metric == "" {
+				return nil, fmt.Errorf("line %d: invalid field %q", n, field)
+			}
+
+			// Integers carry an i or u suffix; strings and booleans are rejected.
+			if strings.HasSuffix(raw, "i") || strings.HasSuffix(raw, "u") {
+				raw = raw[:len(raw)-1]
+			}
+			value, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s is not a number", n, metric)
+			}
+			samples = append(samples, TelemetrySample{DeviceUUID: id.String(), Metric: metric, Time: t, Value: value})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("invalid request body")
+	}
+
+	return samples, nil
+}
+
+// Sample times must be representable as Unix nanoseconds, which the memory
+// store keys them by.
+var (
+	minTelemetryTime = time.Unix(0, math.MinInt64)
+	maxTelemetryTime = time.Unix(0, math.MaxInt64)
+)
+
+// telemetryTime truncates t to the microseconds Postgres stores, so both
+// stores see the same time, and reports whether it is within range.
+func telemetryTime(t time.Time) (time.Time, bool) {
+	t = t.Truncate(time.Microsecond)
+	return t, !t.Before(minTelemetryTime) && !t.After(maxTelemetryTime)
+}
+
+// validateTelemetry checks that every sample reports an allowed metric with a
+// finite value and was not taken after notAfter.
+func validateTelemetry(samples []TelemetrySample, metrics []string, notAfter time.Time) error {
+	allowed := make(map[string]bool, len(metrics))
+	for _, m := range metrics {
+		allowed[m] = true
+	}
+
+	for _, s := range samples {
+		if !allowed[s.Metric] {
+			return fmt.Errorf("unknown metric %q: expected one of %s", s.Metric, strings.Join(metrics, ", "))
+		}
+		if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+			return fmt.Errorf("device %s: %s must be finite", s.DeviceUUID, s.Metric)
+		}
+		if s.Time.After(notAfter) {
+			return fmt.Errorf("device %s: %s time %s is in the future", s.DeviceUUID, s.Metric, s.Time.UTC().Format(time.RFC3339))
+		}
+	}
+
+	return nil
+}
+
+// telemetryGauges exports the latest telemetry values as gauges by device and
+// metric. Metrics are limited by the config and only maxDevices devices are
+// exported at a time, so the label cardinality stays bounded. Devices without
+// samples for expireAfter give up their slot.
+type telemetryGauges struct {
+	mu          sync.Mutex
+	gauge       *prometheus.GaugeVec
+	maxDevices  int
+	expireAfter time.Duration
+
+	// devices holds the exported devices by UUID.
+	devices map[string]*gaugeDevice
+}
+
+// gaugeDevice is a device exported by telemetryGauges.
+type gaugeDevice struct {
+	// seen is when the last sample of the device was received.
+	seen time.Time
+
+	// latest holds the time of the exported sample by metric.
+	latest map[string]time.Time
+}
+
+// newTelemetryGauges returns an exporter that sets the gauge.
+func newTelemetryGauges(gauge *prometheus.GaugeVec, maxDevices int, expireAfter time.Duration) *telemetryGauges {
+	return &telemetryGauges{gauge: gauge, maxDevices: maxDevices, expireAfter: expireAfter, devices: make(map[string]*gaugeDevice)}
+}
+
+// observe exports the samples received at now that are newer than the
+// exported values. When all slots are taken, silent devices are expired first.
+func (g *telemetryGauges) observe(samples []TelemetrySample, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	expired := false
+	for _, s := range samples {
+		d, ok := g.devices[s.DeviceUUID]
+		if !ok {
+			if len(g.devices) >= g.maxDevices && !expired {
+				g.expire(now)
+				expired = true
+			}
+			if len(g.devices) >= g.maxDevices {
+				continue
+			}
+			d = &gaugeDevice{latest: make(map[string]time.Time)}
+			g.devices[s.DeviceUUID] = d
+		}
+		d.seen = now
+
+		if last, ok := d.latest[s.Metric]; ok && !s.Time.After(last) {
+			continue
+		}
+		d.latest[s.Metric] = s.Time
+		g.gauge.With(prometheus.Labels{"device": s.DeviceUUID, "metric": s.Metric}).Set(s.Value)
+	}
+}
+
+// expireSilent stops exporting the devices without samples for expireAfter,
+// so their stale values disappear and their slots go to active devices.
+func (g *telemetryGauges) expireSilent(now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.expire(now)
+}
+
+// expire removes the silent devices; the caller holds the lock.
+func (g *telemetryGauges) expire(now time.Time) {
+	for id, d := range g.devices {
+		if now.Sub(d.seen) >= g.expireAfter {
+			delete(g.devices, id)
+			g.gauge.DeletePartialMatch(prometheus.Labels{"device": id})
+		}
+	}
+}
+
+// forget stops exporting the device, making room for another one.
+func (g *telemetryGauges) forget(deviceUUID string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.devices, deviceUUID)
+	g.gauge.DeletePartialMatch(prometheus.Labels{"device": deviceUUID})
+}
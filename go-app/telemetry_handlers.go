@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultTelemetryRange is how far back telemetry queries look without a from parameter.
+const defaultTelemetryRange = 24 * time.Hour
+
+// postTelemetry stores a batch of device readings sent as JSON keyed by
+// device UUID or, for any other content type, as line protocol.
+func (h *handler) postTelemetry(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP POST /api/telemetry")
+	defer span.End()
+
+	// Record metrics for this operation
+	start := time.Now()
+	defer func() {
+		h.metrics.duration.With(prometheus.Labels{"op": "telemetry"}).Observe(time.Since(start).Seconds())
+	}()
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxTelemetryBody))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"message": "request body too large"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid request body"})
+		return
+	}
+
+	var samples []TelemetrySample
+	if c.ContentType() == "application/json" {
+		samples, err = parseTelemetryJSON(body, start)
+	} else {
+		precision, ok := linePrecisions[c.DefaultQuery("precision", "ns")]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid precision: expected ns, us, ms or s"})
+			return
+		}
+		samples, err = parseLineProtocol(body, precision, start)
+	}
+	if err == nil {
+		// Allow for some clock skew between the devices and the server.
+		maxFuture := time.Duration(h.config.Telemetry.MaxFutureSeconds) * time.Second
+		err = validateTelemetry(samples, h.config.Telemetry.Metrics, start.Add(maxFuture))
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if len(samples) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "no samples"})
+		return
+	}
+	if len(samples) > h.config.Telemetry.MaxBatch {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"message": fmt.Sprintf("at most %d samples per request", h.config.Telemetry.MaxBatch)})
+		return
+	}
+
+	// Reject the whole batch if any device is unknown rather than storing part of it.
+	seen := make(map[string]bool)
+	for _, s := range samples {
+		if seen[s.DeviceUUID] {
+			continue
+		}
+		seen[s.DeviceUUID] = true
+
+		_, err := h.devices.Get(ctx, s.DeviceUUID)
+		if errors.Is(err, ErrDeviceNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "device not found", "uuid": s.DeviceUUID})
+			return
+		}
+		if err != nil {
+			log.Printf("devices.Get failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+			return
+		}
+	}
+
+	stored, err := h.telemetry.Insert(ctx, samples)
+	if errors.Is(err, ErrDeviceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "device not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("telemetry.Insert failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	h.telemetryGauges.observe(samples, start)
+	for _, s := range samples {
+		h.metrics.telemetrySamples.With(prometheus.Labels{"metric": s.Metric}).Inc()
+	}
+
+	c.JSON(http.StatusOK, gin.H{"received": len(samples), "stored": stored})
+}
+
+// getTelemetry responds with the readings of a device aggregated into time
+// buckets of step, by metric.
+func (h *handler) getTelemetry(c *gin.Context) {
+	// Create a new ROOT span to record and trace the request.
+	ctx, span := tracer.Start(c, "HTTP GET /api/devices/:uuid/telemetry")
+	defer span.End()
+
+	id, ok := deviceUUID(c)
+	if !ok {
+		return
+	}
+
+	q, err := h.telemetryQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	q.DeviceUUID = id
+
+	if _, err := h.devices.Get(ctx, id); errors.Is(err, ErrDeviceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "device not found"})
+		return
+	} else if err != nil {
+		log.Printf("devices.Get failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	series, err := h.telemetry.Query(ctx, q)
+	if err != nil {
+		log.Printf("telemetry.Query failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"uuid":        id,
+		"from":        q.From,
+		"to":          q.To,
+		"stepSeconds": int64(q.Step.Seconds()),
+		"series":      series,
+	})
+}
+
+// telemetryQuery parses the metric, from, to and step query parameters. The
+// range defaults to the last day and the step to a hundredth of the range.
+// Steps are Go durations such as 5m or whole seconds.
+func (h *handler) telemetryQuery(c *gin.Context) (TelemetryQuery, error) {
+	q := TelemetryQuery{Metric: c.Query("metric"), To: time.Now()}
+
+	if q.Metric != "" && !slices.Contains(h.config.Telemetry.Metrics, q.Metric) {
+		return q, fmt.Errorf("unknown metric %q", q.Metric)
+	}
+
+	to, err := queryTime(c, "to")
+	if err != nil {
+		return q, err
+	}
+	if to != nil {
+		q.To = *to
+	}
+
+	q.From = q.To.Add(-defaultTelemetryRange)
+	from, err := queryTime(c, "from")
+	if err != nil {
+		return q, err
+	}
+	if from != nil {
+		q.From = *from
+	}
+	if !q.From.Before(q.To) {
+		return q, fmt.Errorf("from must be before to")
+	}
+
+	q.Step = q.To.Sub(q.From) / 100
+	if s := c.Query("step"); s != "" {
+		if q.Step, err = time.ParseDuration(s); err != nil {
+			seconds, err := strconv.Atoi(s)
+			if err != nil {
+				return q, fmt.Errorf("invalid step: expected a duration such as 5m or seconds")
+			}
+			q.Step = time.Duration(seconds) * time.Second
+		}
+		if q.Step < time.Second {
+			return q, fmt.Errorf("step must be at least 1s")
+		}
+	}
+	q.Step = max(q.Step.Truncate(time.Second), time.Second)
+
+	// Buckets are aligned to the epoch, so the range may touch one more.
+	if buckets := int64(q.To.Sub(q.From)/q.Step) + 1; buckets > int64(h.config.Telemetry.MaxBuckets) {
+		return q, fmt.Errorf("step too small: at most %d buckets per metric", h.config.Telemetry.MaxBuckets)
+	}
+
+	return q, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// telemetryKey identifies a stored sample.
+type telemetryKey struct {
+	device, metric string
+	time           int64
+}
+
+// memoryTelemetryStore keeps device telemetry in memory; it is meant for tests and demos.
+type memoryTelemetryStore struct {
+	mu      sync.RWMutex
+	samples map[telemetryKey]float64
+}
+
+// newMemoryTelemetryStore returns an empty in-memory store.
+func newMemoryTelemetryStore() *memoryTelemetryStore {
+	return &memoryTelemetryStore{samples: make(map[telemetryKey]float64)}
+}
+
+// Insert saves the samples that are not stored yet.
+func (s *memoryTelemetryStore) Insert(ctx context.Context, samples []TelemetrySample) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for _, sample := range samples {
+		key := telemetryKey{sample.DeviceUUID, sample.Metric, sample.Time.UnixNano()}
+		if _, ok := s.samples[key]; ok {
+			continue
+		}
+		s.samples[key] = sample.Value
+		n++
+	}
+
+	return n, nil
+}
+
+// Query aggregates the samples of the device into time buckets.
+func (s *memoryTelemetryStore) Query(ctx context.Context, q TelemetryQuery) (map[string][]TelemetryBucket, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	type bucketKey struct {
+		metric string
+		start  int64
+	}
+	buckets := make(map[bucketKey]*TelemetryBucket)
+	sums := make(map[bucketKey]float64)
+
+	from, to, step := q.From.UnixNano(), q.To.UnixNano(), int64(q.Step)
+	for key, value := range s.samples {
+		if key.device != q.DeviceUUID || key.time < from || key.time >= to || (q.Metric != "" && key.metric != q.Metric) {
+			continue
+		}
+
+		// Floor division, so buckets before 1970 are aligned too.
+		start := key.time / step * step
+		if key.time < 0 && key.time%step != 0 {
+			start -= step
+		}
+
+		k := bucketKey{key.metric, start}
+		b, ok := buckets[k]
+		if !ok {
+			b = &TelemetryBucket{Time: time.Unix(0, start).UTC(), Min: value, Max: value}
+			buckets[k] = b
+		}
+		b.Min = min(b.Min, value)
+		b.Max = max(b.Max, value)
+		b.Count++
+		sums[k] += value
+	}
+
+	series := make(map[string][]TelemetryBucket)
+	for k, b := range buckets {
+		b.Avg = sums[k] / float64(b.Count)
+		series[k.metric] = append(series[k.metric], *b)
+	}
+	for _, buckets := range series {
+		sort.Slice(buckets, func(i, j int) bool { return buckets[i].Time.Before(buckets[j].Time) })
+	}
+
+	return series, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// pgTelemetryStore stores device telemetry in Postgres.
+type pgTelemetryStore struct {
+	// Postgres connection pool
+	dbpool *pgxpool.Pool
+
+	// Prometheus metrics
+	metrics *metrics
+}
+
+// newPgTelemetryStore returns a store backed by the go_device_telemetry table.
+func newPgTelemetryStore(dbpool *pgxpool.Pool, m *metrics) *pgTelemetryStore {
+	return &pgTelemetryStore{dbpool: dbpool, metrics: m}
+}
+
+// Insert saves the samples with a single statement.
+func (s *pgTelemetryStore) Insert(ctx context.Context, samples []TelemetrySample) (int64, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL INSERT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	devices := make([]string, len(samples))
+	metricNames := make([]string, len(samples))
+	times := make([]time.Time, len(samples))
+	values := make([]float64, len(samples))
+	for i, sample := range samples {
+		devices[i], metricNames[i], times[i], values[i] = sample.DeviceUUID, sample.Metric, sample.Time, sample.Value
+	}
+
+	query := `INSERT INTO go_device_telemetry (device_uuid, metric, time, value)
+		SELECT d::uuid, m, t, v FROM unnest($1::text[], $2::text[], $3::timestamptz[], $4::float8[]) AS s (d, m, t, v)
+		ON CONFLICT DO NOTHING`
+
+	tag, err := s.dbpool.Exec(ctx, query, devices, metricNames, times, values)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+		// A device was deleted after the handler looked it up.
+		return 0, ErrDeviceNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("dbpool.Exec failed: %w", err)
+	}
+
+	// Record the duration of the insert query.
+	s.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return tag.RowsAffected(), nil
+}
+
+// Query aggregates the samples of the device into time buckets.
+func (s *pgTelemetryStore) Query(ctx context.Context, q TelemetryQuery) (map[string][]TelemetryBucket, error) {
+	// Create a new CHILD span to record and trace the request.
+	ctx, span := tracer.Start(ctx, "SQL SELECT")
+	defer span.End()
+
+	// Get the current time to record the duration of the request.
+	now := time.Now()
+
+	query := `SELECT metric, to_timestamp(floor(extract(epoch FROM time)::float8 / $4) * $4) AS bucket,
+			avg(value), min(value), max(value), count(*)
+		FROM go_device_telemetry
+		WHERE device_uuid = $1 AND time >= $2 AND time < $3 AND ($5 = '' OR metric = $5)
+		GROUP BY metric, bucket ORDER BY metric, bucket`
+
+	rows, err := s.dbpool.Query(ctx, query, q.DeviceUUID, q.From, q.To, q.Step.Seconds(), q.Metric)
+	if err != nil {
+		return nil, fmt.Errorf("dbpool.Query failed: %w", err)
+	}
+
+	series := make(map[string][]TelemetryBucket)
+	var (
+		metric string
+		b      TelemetryBucket
+	)
+	_, err = pgx.ForEachRow(rows, []any{&metric, &b.Time, &b.Avg, &b.Min, &b.Max, &b.Count}, func() error {
+		series[metric] = append(series[metric], b)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pgx.ForEachRow failed: %w", err)
+	}
+
+	// Record the duration of the select query.
+	s.metrics.duration.With(prometheus.Labels{"op": "db"}).Observe(time.Since(now).Seconds())
+
+	return series, nil
+}
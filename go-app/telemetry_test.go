@@ -0,0 +1,366 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	deviceA = "b0e42fe7-31a4-4e0b-9c3d-0a1b2c3d4e5f"
+	deviceB = "5c1d0e2f-7a8b-4c9d-8e0f-1a2b3c4d5e6f"
+	deviceC = "9f8e7d6c-5b4a-4392-8170-f1e2d3c4b5a6"
+)
+
+// checkSamples compares the samples regardless of their order.
+func checkSamples(t *testing.T, got, want []TelemetrySample) {
+	t.Helper()
+
+	sort.Slice(got, func(i, j int) bool {
+		if got[i].DeviceUUID != got[j].DeviceUUID {
+			return got[i].DeviceUUID < got[j].DeviceUUID
+		}
+		return got[i].Metric < got[j].Metric
+	})
+	if len(got) != len(want) {
+		t.Fatalf("got %d samples %+v, want %d", len(got), got, len(want))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.DeviceUUID != w.DeviceUUID || g.Metric != w.Metric || !g.Time.Equal(w.Time) || g.Value != w.Value {
+			t.Errorf("got sample %+v, want %+v", g, w)
+		}
+	}
+}
+
+func TestParseLineProtocol(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 123456789, time.UTC)
+	at := time.Unix(1714557600, 0)
+
+	tests := []struct {
+		name      string
+		body      string
+		precision time.Duration
+		want      []TelemetrySample
+		wantErr   string
+	}{
+		{
+			name:      "fields and suffixes",
+			body:      "telemetry,device=" + deviceA + " temperature=21.5,battery=87i,signal=3u 1714557600",
+			precision: time.Second,
+			want: []TelemetrySample{
+				{deviceA, "battery", at, 87},
+				{deviceA, "signal", at, 3},
+				{deviceA, "temperature", at, 21.5},
+			},
+		},
+		{
+			name:      "other tags, comments and blank lines",
+			body:      "# readings\n\ntelemetry,site=lab,device=" + deviceA + ",rack=2 battery=87 1714557600000\n",
+			precision: time.Millisecond,
+			want:      []TelemetrySample{{deviceA, "battery", at, 87}},
+		},
+		{
+			name:      "no timestamp",
+			body:      "telemetry,device=" + deviceA + " battery=87",
+			precision: time.Nanosecond,
+			want:      []TelemetrySample{{deviceA, "battery", now.Truncate(time.Microsecond), 87}},
+		},
+		{
+			name:      "nanoseconds truncated",
+			body:      "telemetry,device=" + deviceA + " battery=87 1714557600123456789",
+			precision: time.Nanosecond,
+			want:      []TelemetrySample{{deviceA, "battery", time.Unix(1714557600, 123456000), 87}},
+		},
+		{
+			name:      "unknown metric left to validation",
+			body:      "telemetry,device=" + deviceA + " humidity=40",
+			precision: time.Nanosecond,
+			want:      []TelemetrySample{{deviceA, "humidity", now.Truncate(time.Microsecond), 40}},
+		},
+		{
+			name:      "missing device tag",
+			body:      "telemetry,site=lab battery=87",
+			precision: time.Nanosecond,
+			wantErr:   "line 1: missing or invalid device tag",
+		},
+		{
+			name:      "invalid device tag",
+			body:      "telemetry,device=sensor-1 battery=87",
+			precision: time.Nanosecond,
+			wantErr:   "line 1: missing or invalid device tag",
+		},
+		{
+			name:      "error on later line",
+			body:      "telemetry,device=" + deviceA + " battery=87\ntelemetry battery=87",
+			precision: time.Nanosecond,
+			wantErr:   "line 2: missing or invalid device tag",
+		},
+		{
+			name:      "precision overflow",
+			body:      "telemetry,device=" + deviceA + " battery=87 9223372037",
+			precision: time.Second,
+			wantErr:   "line 1: timestamp out of range",
+		},
+		{
+			name:      "negative precision overflow",
+			body:      "telemetry,device=" + deviceA + " battery=87 -9223372037",
+			precision: time.Second,
+			wantErr:   "line 1: timestamp out of range",
+		},
+		{
+			name:      "below range after truncation",
+			body:      "telemetry,device=" + deviceA + " battery=87 -9223372036854775808",
+			precision: time.Nanosecond,
+			wantErr:   "line 1: timestamp out of range",
+		},
+		{
+			name:      "invalid timestamp",
+			body:      "telemetry,device=" + deviceA + " battery=87 yesterday",
+			precision: time.Nanosecond,
+			wantErr:   "line 1: invalid timestamp",
+		},
+		{
+			name:      "string field",
+			body:      "telemetry,device=" + deviceA + ` state="ok"`,
+			precision: time.Nanosecond,
+			wantErr:   "line 1: state is not a number",
+		},
+		{
+			name:      "boolean field",
+			body:      "telemetry,device=" + deviceA + " charging=t",
+			precision: time.Nanosecond,
+			wantErr:   "line 1: charging is not a number",
+		},
+		{
+			name:      "field without value",
+			body:      "telemetry,device=" + deviceA + " battery",
+			precision: time.Nanosecond,
+			wantErr:   `line 1: invalid field "battery"`,
+		},
+		{
+			name:      "no fields",
+			body:      "telemetry,device=" + deviceA,
+			precision: time.Nanosecond,
+			wantErr:   "line 1: expected measurement,tags fields [timestamp]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLineProtocol([]byte(tt.body), tt.precision, now)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseLineProtocol failed: %v", err)
+			}
+			checkSamples(t, got, tt.want)
+		})
+	}
+}
+
+func TestParseTelemetryJSON(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 123456789, time.UTC)
+	at := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		body    string
+		want    []TelemetrySample
+		wantErr string
+	}{
+		{
+			name: "points of several devices",
+			body: `{"` + deviceA + `": [{"time": "2024-05-01T09:00:00Z", "temperature": 21.5, "battery": 87}],
+				"` + strings.ToUpper(deviceB) + `": [{"time": "2024-05-01T11:00:00+02:00", "signal": -70}]}`,
+			want: []TelemetrySample{
+				{deviceB, "signal", at, -70},
+				{deviceA, "battery", at, 87},
+				{deviceA, "temperature", at, 21.5},
+			},
+		},
+		{
+			name: "no time",
+			body: `{"` + deviceA + `": [{"battery": 87}]}`,
+			want: []TelemetrySample{{deviceA, "battery", now.Truncate(time.Microsecond), 87}},
+		},
+		{
+			name: "nanoseconds truncated",
+			body: `{"` + deviceA + `": [{"time": "2024-05-01T09:00:00.123456789Z", "battery": 87}]}`,
+			want: []TelemetrySample{{deviceA, "battery", at.Add(123456 * time.Microsecond), 87}},
+		},
+		{
+			name: "unknown metric left to validation",
+			body: `{"` + deviceA + `": [{"humidity": 40}]}`,
+			want: []TelemetrySample{{deviceA, "humidity", now.Truncate(time.Microsecond), 40}},
+		},
+		{
+			name:    "invalid body",
+			body:    `[{"battery": 87}]`,
+			wantErr: "invalid request body",
+		},
+		{
+			name:    "invalid device",
+			body:    `{"sensor-1": [{"battery": 87}]}`,
+			wantErr: `invalid device uuid "sensor-1"`,
+		},
+		{
+			name:    "invalid time",
+			body:    `{"` + deviceA + `": [{"time": "yesterday", "battery": 87}]}`,
+			wantErr: "device " + deviceA + ": invalid time: expected RFC 3339 timestamp",
+		},
+		{
+			name:    "time after 2262",
+			body:    `{"` + deviceA + `": [{"time": "2300-01-01T00:00:00Z", "battery": 87}]}`,
+			wantErr: "device " + deviceA + ": time out of range",
+		},
+		{
+			name:    "time before 1678",
+			body:    `{"` + deviceA + `": [{"time": "1600-01-01T00:00:00Z", "battery": 87}]}`,
+			wantErr: "device " + deviceA + ": time out of range",
+		},
+		{
+			name:    "string value",
+			body:    `{"` + deviceA + `": [{"battery": "87"}]}`,
+			wantErr: "device " + deviceA + ": battery is not a number",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTelemetryJSON([]byte(tt.body), now)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTelemetryJSON failed: %v", err)
+			}
+			checkSamples(t, got, tt.want)
+		})
+	}
+}
+
+func TestValidateTelemetry(t *testing.T) {
+	metrics := []string{"temperature", "battery"}
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		sample  TelemetrySample
+		wantErr string
+	}{
+		{"valid", TelemetrySample{deviceA, "battery", now.Add(-time.Hour), 87}, ""},
+		{"at the limit", TelemetrySample{deviceA, "battery", now, 87}, ""},
+		{"negative", TelemetrySample{deviceA, "temperature", now, -12.5}, ""},
+		{"unknown metric", TelemetrySample{deviceA, "humidity", now, 40},
+			`unknown metric "humidity": expected one of temperature, battery`},
+		{"not a number", TelemetrySample{deviceA, "battery", now, math.NaN()},
+			"device " + deviceA + ": battery must be finite"},
+		{"infinite", TelemetrySample{deviceA, "temperature", now, math.Inf(-1)},
+			"device " + deviceA + ": temperature must be finite"},
+		{"future", TelemetrySample{deviceA, "battery", now.Add(time.Second), 87},
+			"device " + deviceA + ": battery time 2024-05-01T10:00:01Z is in the future"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples := []TelemetrySample{{deviceB, "battery", now, 50}, tt.sample}
+			err := validateTelemetry(samples, metrics, now)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateTelemetry failed: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// gaugeValues returns the exported telemetry gauges by device and metric.
+func gaugeValues(t *testing.T, reg *prometheus.Registry) map[string]float64 {
+	t.Helper()
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("reg.Gather failed: %v", err)
+	}
+
+	values := make(map[string]float64)
+	for _, f := range families {
+		if f.GetName() != "myapp_device_telemetry" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			labels := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			values[labels["device"]+"/"+labels["metric"]] = m.GetGauge().GetValue()
+		}
+	}
+
+	return values
+}
+
+func TestTelemetryGauges(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	g := newTelemetryGauges(NewMetrics(reg).telemetry, 2, time.Minute)
+
+	t0 := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	check := func(step string, want map[string]float64) {
+		t.Helper()
+
+		got := gaugeValues(t, reg)
+		if len(got) != len(want) {
+			t.Fatalf("%s: got gauges %v, want %v", step, got, want)
+		}
+		for k, v := range want {
+			if got[k] != v {
+				t.Errorf("%s: got gauges %v, want %v", step, got, want)
+			}
+		}
+	}
+
+	g.observe([]TelemetrySample{
+		{deviceA, "battery", t0, 80},
+		{deviceB, "battery", t0, 60},
+	}, t0)
+	check("first samples", map[string]float64{deviceA + "/battery": 80, deviceB + "/battery": 60})
+
+	// All slots are taken by active devices, so the third one is left out.
+	// Samples older than the exported one do not replace it.
+	t1 := t0.Add(30 * time.Second)
+	g.observe([]TelemetrySample{
+		{deviceC, "battery", t1, 40},
+		{deviceA, "battery", t1, 79},
+		{deviceA, "battery", t0.Add(-time.Minute), 95},
+		{deviceA, "temperature", t1, 21.5},
+	}, t1)
+	check("slots taken", map[string]float64{
+		deviceA + "/battery": 79, deviceA + "/temperature": 21.5, deviceB + "/battery": 60,
+	})
+
+	// The silent device gives up its slot to the new one.
+	t2 := t0.Add(70 * time.Second)
+	g.observe([]TelemetrySample{{deviceC, "battery", t2, 40}}, t2)
+	check("slot evicted", map[string]float64{
+		deviceA + "/battery": 79, deviceA + "/temperature": 21.5, deviceC + "/battery": 40,
+	})
+
+	g.expireSilent(t1.Add(time.Minute))
+	check("expired", map[string]float64{deviceC + "/battery": 40})
+
+	g.forget(deviceC)
+	check("forgotten", map[string]float64{})
+}
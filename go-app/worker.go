@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ingest saves a newly uploaded image and queues it for processing. When an
+// image with the same content already exists, it returns that image instead
+// and reports it as a duplicate.
+func (h *handler) ingest(ctx context.Context, image *Image) (*Image, bool, error) {
+	err := h.images.Insert(ctx, image)
+	if errors.Is(err, ErrDuplicateContent) {
+		existing, err := h.images.GetByHash(ctx, image.ContentHash)
+		if err != nil {
+			return nil, false, fmt.Errorf("images.GetByHash failed: %w", err)
+		}
+		return existing, true, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("images.Insert failed: %w", err)
+	}
+
+	if err := h.jobs.Enqueue(ctx, image.ImageUUID); err != nil {
+		return nil, false, fmt.Errorf("jobs.Enqueue failed: %w", err)
+	}
+
+	return image, false, nil
+}
+
+// startWorkers starts the bounded pool of image processing workers and the queue monitor.
+func (h *handler) startWorkers(ctx context.Context) {
+	for i := 0; i < h.config.Workers.Count; i++ {
+		go h.worker(ctx)
+	}
+
+	go h.monitorQueue(ctx)
+
+	log.Printf("Started %d image processing workers", h.config.Workers.Count)
+}
+
+// worker claims and processes jobs until the context is cancelled.
+func (h *handler) worker(ctx context.Context) {
+	poll := time.Duration(h.config.Workers.PollIntervalMs) * time.Millisecond
+
+	for {
+		job, err := h.jobs.Claim(ctx)
+		if err != nil {
+			if !errors.Is(err, ErrNoJob) {
+				log.Printf("jobs.Claim failed: %v", err)
+			}
+
+			// Wait before polling the queue again.
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(poll):
+			}
+			continue
+		}
+
+		h.runJob(ctx, job)
+	}
+}
+
+// runJob processes the job and records its outcome in the queue and in metrics.
+func (h *handler) runJob(ctx context.Context, job *Job) {
+	err := h.process(ctx, job.ImageUUID)
+
+	result := StatusProcessed
+	switch {
+	case err == nil || errors.Is(err, ErrImageNotFound):
+		// The image may have been deleted while the job was waiting.
+		if err := h.jobs.Complete(ctx, job); err != nil {
+			log.Printf("jobs.Complete failed: %v", err)
+		}
+	case job.Attempts < h.config.Workers.MaxAttempts:
+		result = "retry"
+		log.Printf("job %d for image %s failed, retrying: %v", job.ID, job.ImageUUID, err)
+
+		// Back off linearly so a flapping dependency is not hammered.
+		delay := time.Duration(job.Attempts) * 10 * time.Second
+		if err := h.jobs.Retry(ctx, job, err.Error(), delay); err != nil {
+			log.Printf("jobs.Retry failed: %v", err)
+		}
+	default:
+		result = "failed"
+		log.Printf("job %d for image %s failed, giving up: %v", job.ID, job.ImageUUID, err)
+
+		if err := h.jobs.Fail(ctx, job, err.Error()); err != nil {
+			log.Printf("jobs.Fail failed: %v", err)
+		}
+		if err := h.images.UpdateStatus(ctx, job.ImageUUID, StatusError, err.Error()); err != nil {
+			log.Printf("images.UpdateStatus failed: %v", err)
+		}
+	}
+
+	// Record how long the image waited in the queue plus its processing time.
+	h.metrics.jobLatency.With(prometheus.Labels{"result": result}).Observe(time.Since(job.CreatedAt).Seconds())
+}
+
+// process downloads the image, detects its format and dimensions and generates
+// derivatives, moving it through processing to processed or error.
+func (h *handler) process(ctx context.Context, id string) error {
+	// Create a new ROOT span to record and trace the job.
+	ctx, span := tracer.Start(ctx, "JOB process image")
+	defer span.End()
+
+	// Record metrics for this operation
+	start := time.Now()
+	defer func() {
+		h.metrics.duration.With(prometheus.Labels{"op": "process"}).Observe(time.Since(start).Seconds())
+	}()
+
+	image, err := h.images.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	switch image.Status {
+	case StatusUploaded:
+		if err := h.images.UpdateStatus(ctx, id, StatusProcessing, "picked up by worker"); err != nil {
+			return err
+		}
+	case StatusProcessing:
+		// A previous attempt failed or its worker crashed; try again.
+	default:
+		// Another job already finished the image.
+		log.Printf("image %s is already %s, skipping", id, image.Status)
+		return nil
+	}
+
+	// Open the image in the object store; it is streamed, never held in memory as a whole.
+	obj, err := h.store.Get(ctx, image.ObjectKey)
+	if err != nil {
+		return fmt.Errorf("store.Get failed: %w", err)
+	}
+	defer obj.Body.Close()
+
+	// Hash the bytes while they are decoded if the image has no hash yet.
+	body := &errReader{r: obj.Body}
+	var r io.Reader = body
+	hash := sha256.New()
+	if image.ContentHash == "" {
+		r = io.TeeReader(body, hash)
+	}
+
+	// Detect the content type and dimensions from the stream; the size comes
+	// from the object metadata.
+	image.FileSize = obj.Size
+	image.Status, image.StatusReason = StatusProcessed, ""
+	src := image.analyze(r)
+	image.ProcessedAt = time.Now()
+
+	// A broken connection is not a broken image; retry the job instead.
+	if body.err != nil {
+		return fmt.Errorf("read failed: %w", body.err)
+	}
+
+	if image.ContentHash == "" {
+		// The decoder may stop early, so read the rest to finish the hash.
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return fmt.Errorf("io.Copy failed: %w", err)
+		}
+		h.recordHash(ctx, image, hex.EncodeToString(hash.Sum(nil)))
+	}
+
+	if err := h.images.UpdateDetails(ctx, image); err != nil {
+		return err
+	}
+
+	// Generate thumbnails and other derivatives.
+	h.derive(ctx, image, src)
+
+	if err := h.images.UpdateStatus(ctx, id, image.Status, image.StatusReason); err != nil {
+		return fmt.Errorf("images.UpdateStatus failed: %w", err)
+	}
+
+	return nil
+}
+
+// errReader remembers the first read error of the underlying reader so that
+// transport failures can be told apart from corrupt images.
+type errReader struct {
+	r   io.Reader
+	err error
+}
+
+// Read reads from the underlying reader and records any error but EOF.
+func (e *errReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF && e.err == nil {
+		e.err = err
+	}
+	return n, err
+}
+
+// recordHash stores the content hash of an image ingested before hashing
+// existed. A duplicate is only logged; the dedupe command collapses those.
+func (h *handler) recordHash(ctx context.Context, image *Image, hash string) {
+	err := h.images.SetContentHash(ctx, image.ImageUUID, hash)
+	if errors.Is(err, ErrDuplicateContent) {
+		log.Printf("image %s duplicates existing content %s", image.ImageUUID, hash)
+		return
+	}
+	if err != nil {
+		log.Printf("images.SetContentHash failed: %v", err)
+		return
+	}
+
+	image.ContentHash = hash
+}
+
+// monitorQueue periodically exports the number of queued jobs.
+func (h *handler) monitorQueue(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(h.config.Workers.PollIntervalMs) * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		depth, err := h.jobs.Depth(ctx)
+		if err != nil {
+			log.Printf("jobs.Depth failed: %v", err)
+		} else {
+			h.metrics.queueDepth.Set(float64(depth))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}